@@ -0,0 +1,262 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"time"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ASTAR_SEARCH_BUDGET bounds the partial trees a single search may expand
+const ASTAR_SEARCH_BUDGET = 200000
+
+// SearchEfficiency compares the work of the AStar method with the BFS method
+// on the same request
+type SearchEfficiency struct {
+	Expanded    int `json:"expanded"`     // Partial trees expanded by A*
+	BFSExpanded int `json:"bfs_expanded"` // Nodes expanded by the shortest BFS
+}
+
+// openNode is an element of a partial tree still waiting for a recipe. Open
+// nodes are shared between the partial trees that contain them.
+type openNode struct {
+	name   string
+	depth  int
+	parent *openNode
+}
+
+// searchState is a partial tree, stored as the last decision made on top of
+// its parent state
+type searchState struct {
+	parent   *searchState
+	node     *openNode    // node decided by this state
+	pair     graph.Pair   // recipe chosen for node
+	children [2]*openNode // open nodes made for the ingredients, nil for base ones
+	open     []*openNode  // nodes still waiting for a recipe, oldest first
+	cost     int          // combinations decided so far
+	estimate int          // lower bound on the combinations still needed
+	order    int          // creation order, for stable tie breaking
+
+	recipeStep int // trace step that reported the decision
+	expandStep int // trace step that expanded open[0] of this state
+}
+
+// stateQueue is a min-heap of partial trees by cost plus estimate, then by
+// estimate so nearly complete trees come first, then by creation order
+type stateQueue []*searchState
+
+func (q stateQueue) Len() int { return len(q) }
+func (q stateQueue) Less(i, j int) bool {
+	fi, fj := q[i].cost+q[i].estimate, q[j].cost+q[j].estimate
+	if fi != fj {
+		return fi < fj
+	}
+	if q[i].estimate != q[j].estimate {
+		return q[i].estimate < q[j].estimate
+	}
+	return q[i].order < q[j].order
+}
+func (q stateQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *stateQueue) Push(x any)   { *q = append(*q, x.(*searchState)) }
+func (q *stateQueue) Pop() any {
+	old := *q
+	state := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return state
+}
+
+/*	A* over partial recipe trees
+*
+*	A state is a partial tree and a step picks a recipe for its oldest open
+*	node, so every partial tree is reached in exactly one way. A step costs one
+*	combination and the goal is a tree without open nodes, which makes the
+*	first goal popped a tree with the fewest combinations.
+*
+*	The estimate of a partial tree sums a lower bound over its open nodes,
+*	which is admissible because the subtrees of a tree are disjoint. The tier
+*	of an element is such a bound, since a tree needs at least as many
+*	combinations as it is high, but it is far too weak for the large trees of
+*	late elements. The fewest combinations of each element with the no-cycle
+*	rule dropped, settled by Knuth's algorithm exactly like the tiers, is a
+*	bound at least as large and still admissible. With maxDepth above 0 the
+*	bound also accounts for the levels left below each open node, and no
+*	ingredient is opened that cannot fit in them at all.
+*
+*	Steps are reported to stats, which may be nil.
+ */
+func astar(ctx context.Context, g *graph.RecipeGraph, target string, budget int, maxDepth int, stats *SearchStats) (*searchState, searchCounts, error) {
+	// bound returns a lower bound on the combinations name needs depth
+	// levels below the target, or -1 when it cannot fit there
+	bound := func(name string, depth int) int {
+		return g.MinCombinations(name)
+	}
+	if maxDepth > 0 {
+		within, err := g.MinCombinationsWithin(ctx, maxDepth)
+		if err != nil {
+			return nil, searchCounts{}, err
+		}
+		bound = func(name string, depth int) int {
+			return within(name, maxDepth-depth)
+		}
+	}
+
+	root := &openNode{name: target}
+	start := &searchState{estimate: bound(target, 0)}
+	if !g.IsBase(target) {
+		start.open = []*openNode{root}
+	}
+
+	queue := &stateQueue{start}
+	order := 1
+	counts := searchCounts{}
+
+	for queue.Len() > 0 {
+		counts.maxFrontier = max(counts.maxFrontier, queue.Len())
+		state := heap.Pop(queue).(*searchState)
+
+		// Partial trees are popped in any order, so report the decision
+		// this one was made with before going on with it
+		if state.parent != nil {
+			state.recipeStep = stats.emitRecipe(state.node.name, state.node.parentName(), state.node.depth, state.pair)
+		}
+		if len(state.open) == 0 {
+			return state, counts, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, counts, err
+		}
+
+		counts.expanded++
+		if counts.expanded > budget {
+			break
+		}
+
+		node := state.open[0]
+		state.expandStep = stats.emitExpand(node.name, node.parentName(), node.depth, queue.Len())
+		for _, pair := range graph.UniqueRecipes(g.Recipes(node.name)) {
+			if g.Tier(pair.First) == -1 || g.Tier(pair.Second) == -1 {
+				continue
+			}
+			if onPath(node, pair.First) || onPath(node, pair.Second) {
+				continue
+			}
+			if bound(pair.First, node.depth+1) == -1 || bound(pair.Second, node.depth+1) == -1 {
+				continue
+			}
+
+			next := &searchState{
+				parent:   state,
+				node:     node,
+				pair:     pair,
+				cost:     state.cost + 1,
+				estimate: state.estimate - bound(node.name, node.depth),
+				order:    order,
+			}
+			order++
+
+			next.open = make([]*openNode, 0, len(state.open)+1)
+			next.open = append(next.open, state.open[1:]...)
+			for i, ingredient := range []string{pair.First, pair.Second} {
+				if g.IsBase(ingredient) {
+					continue
+				}
+				child := &openNode{name: ingredient, depth: node.depth + 1, parent: node}
+				next.children[i] = child
+				next.open = append(next.open, child)
+				next.estimate += bound(ingredient, node.depth+1)
+			}
+
+			heap.Push(queue, next)
+		}
+	}
+
+	return nil, counts, nil
+}
+
+// parentName returns the name of the element node is an ingredient of, or
+// nothing for the target
+func (node *openNode) parentName() string {
+	if node.parent == nil {
+		return ""
+	}
+	return node.parent.name
+}
+
+// onPath reports whether name is node or one of its ancestors
+func onPath(node *openNode, name string) bool {
+	for ; node != nil; node = node.parent {
+		if node.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildSearchTree replays the decisions leading to a complete state. Every
+// node keeps the steps that expanded it in the state before its decision and
+// reported the decision.
+func buildSearchTree(goal *searchState, target string) *tree {
+	decisions := make(map[*openNode]*searchState)
+	var root *openNode
+	for state := goal; state.parent != nil; state = state.parent {
+		decisions[state.node] = state
+		root = state.node
+	}
+
+	var build func(node *openNode, name string, depth int, parent *tree) *tree
+	build = func(node *openNode, name string, depth int, parent *tree) *tree {
+		t := &tree{now: name, depth: depth, parent: parent}
+		if decision, ok := decisions[node]; ok {
+			t.children = []*tree{
+				build(decision.children[0], decision.pair.First, depth+1, t),
+				build(decision.children[1], decision.pair.Second, depth+1, t),
+			}
+			t.childCount = 2
+			t.steps = []int{decision.parent.expandStep, decision.recipeStep}
+		}
+		return t
+	}
+
+	return build(root, target, 0, nil)
+}
+
+// astarSearch finds a tree with the fewest combinations for target within
+// maxDepth levels and lays it out, comparing the work done with the BFS
+// method
+func astarSearch(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, maxDepth int, stats *SearchStats) ([]ImageInfo, []LineInfo, *SearchEfficiency, error) {
+	goal, counts, err := astar(ctx, g, target, ASTAR_SEARCH_BUDGET, maxDepth, stats)
+	stats.add(counts)
+	expanded := counts.expanded
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("AStar for %s stopped after %d expanded nodes: %w", target, expanded, err)
+	}
+	if goal == nil && expanded <= ASTAR_SEARCH_BUDGET {
+		return nil, nil, nil, fmt.Errorf("no recipe for %s within depth %d", target, maxDepth)
+	}
+	if goal == nil {
+		return nil, nil, nil, fmt.Errorf("no recipe for %s found within %d expanded nodes", target, ASTAR_SEARCH_BUDGET)
+	}
+
+	// The BFS method only follows the recipes that achieve each tier, so it
+	// takes one expansion per node of its tree, which is never higher than
+	// the target's tier and so fits in maxDepth once A* found a tree. The
+	// comparison is only informative, so a BFS cut short by ctx leaves it
+	// out and the tree is still returned.
+	var efficiency *SearchEfficiency
+	bfs := &SearchStats{}
+	if _, err := shortestTree(ctx, g, target, false, false, nil, bfs); err == nil {
+		efficiency = &SearchEfficiency{Expanded: expanded, BFSExpanded: bfs.NodesExpanded}
+		fmt.Printf("AStar expanded %d nodes for %s, BFS expanded %d\n", expanded, target, efficiency.BFSExpanded)
+	}
+
+	root := buildSearchTree(goal, target)
+	stats.keep(root)
+
+	defer stats.addLayout(time.Now())
+	recipe := layoutRecipeTree(c, root)
+	return recipe.Images, recipe.Lines, efficiency, nil
+}
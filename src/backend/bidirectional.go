@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*	Bidirectional search
+*
+*	The forward side grows the closure of the base elements one round at a
+*	time. An element joins it in the round after both ingredients of one of
+*	its recipes have, so round r adds exactly the elements of tier r, together
+*	with every recipe that makes them from lower tiers.
+*
+*	The backward side grows an AND-OR graph down from the target. Every
+*	element on its frontier is expanded into all of its tier-minimal recipes,
+*	and the ingredients that still need a recipe make up the next frontier.
+*
+*	Each side only touches its own state while a round runs, so the two can
+*	run concurrently. Between rounds they meet: a backward element the
+*	forward side has already made needs no further expansion. The search is
+*	over once the backward frontier is empty, which takes about half as many
+*	rounds as the target's tier.
+*
+*	Trees are then read off the two graphs: above the meeting line elements
+*	use the recipes the backward side found, below it those the forward side
+*	found, and every leaf is a base element. Both only hold tier-minimal
+*	recipes, so every tree is exactly as high as the target's tier.
+*
+*	Required elements are handed down while the trees are read off: an
+*	element is done at a node of its own name, and otherwise goes to one of
+*	the two ingredients, every way of dividing them being tried. A tree that
+*	contains a required element on both sides is found once per side, so
+*	those repeats are dropped by shape.
+ */
+
+// forwardSide is the closure of the base elements built so far
+type forwardSide struct {
+	recipes  map[string][]graph.Pair // element -> recipes making it from earlier rounds, nil for base elements
+	frontier []string                // elements added in the last round
+	counts   searchCounts
+	steps    sideSteps
+}
+
+// sideSteps are the trace steps one side took for each element and recipe
+type sideSteps struct {
+	expand map[string]int
+	recipe map[recipeStep]int
+	meet   map[string]int
+}
+
+// recipeStep identifies the step that found pair for name
+type recipeStep struct {
+	name string
+	pair graph.Pair
+}
+
+func newSideSteps() sideSteps {
+	return sideSteps{
+		expand: make(map[string]int),
+		recipe: make(map[recipeStep]int),
+		meet:   make(map[string]int),
+	}
+}
+
+// stepOf returns the step recorded for key, if there is one
+func stepOf[K comparable](steps map[K]int, key K) []int {
+	if step, ok := steps[key]; ok {
+		return []int{step}
+	}
+	return nil
+}
+
+// round adds the elements made from the frontier and the elements before it
+func (f *forwardSide) round(g *graph.RecipeGraph, stats *SearchStats) {
+	added := make(map[string][]graph.Pair)
+	next := make([]string, 0)
+
+	for _, name := range f.frontier {
+		f.counts.expanded++
+		f.steps.expand[name] = stats.emit(SearchEvent{Type: "expand", Node: name, Depth: g.Tier(name), Frontier: len(f.frontier), Side: "source"})
+
+		for _, result := range g.NextElements(name) {
+			if _, ok := f.recipes[result]; ok || added[result] != nil || g.IsBase(result) {
+				continue
+			}
+			for _, pair := range graph.UniqueRecipes(g.Recipes(result)) {
+				_, first := f.recipes[pair.First]
+				_, second := f.recipes[pair.Second]
+				if first && second {
+					added[result] = append(added[result], pair)
+				}
+			}
+			if pairs := added[result]; pairs != nil {
+				next = append(next, result)
+				for _, pair := range pairs {
+					f.steps.recipe[recipeStep{result, pair}] = stats.emit(SearchEvent{Type: "recipe", Node: result, Depth: g.Tier(result), First: pair.First, Second: pair.Second, Side: "source"})
+				}
+			}
+		}
+	}
+
+	// Elements of this round may only be used from the next one on
+	for name, pairs := range added {
+		f.recipes[name] = pairs
+	}
+	f.frontier = next
+}
+
+// backwardSide is the AND-OR graph below the target built so far
+type backwardSide struct {
+	recipes  map[string][]graph.Pair // expanded element -> its tier-minimal recipes
+	queued   map[string]bool         // elements on the frontier or expanded
+	frontier []string                // elements still waiting for a recipe
+	depth    int                     // levels below the target the frontier starts at
+	counts   searchCounts
+	steps    sideSteps
+}
+
+// round expands every element of the frontier
+func (b *backwardSide) round(g *graph.RecipeGraph, stats *SearchStats) {
+	next := make([]string, 0)
+
+	for _, name := range b.frontier {
+		b.counts.expanded++
+		b.steps.expand[name] = stats.emit(SearchEvent{Type: "expand", Node: name, Depth: b.depth, Frontier: len(b.frontier), Side: "target"})
+
+		pairs := make([]graph.Pair, 0)
+		for _, pair := range graph.UniqueRecipes(g.Recipes(name)) {
+			first, second := g.Tier(pair.First), g.Tier(pair.Second)
+			if first == -1 || second == -1 || max(first, second)+1 != g.Tier(name) {
+				continue
+			}
+			pairs = append(pairs, pair)
+			b.steps.recipe[recipeStep{name, pair}] = stats.emit(SearchEvent{Type: "recipe", Node: name, Depth: b.depth, First: pair.First, Second: pair.Second, Side: "target"})
+
+			for _, ingredient := range []string{pair.First, pair.Second} {
+				if !g.IsBase(ingredient) && !b.queued[ingredient] {
+					b.queued[ingredient] = true
+					next = append(next, ingredient)
+				}
+			}
+		}
+		b.recipes[name] = pairs
+	}
+
+	b.frontier = next
+	b.depth++
+}
+
+// meet drops the frontier elements the forward side has already made
+func (b *backwardSide) meet(f *forwardSide, stats *SearchStats) {
+	open := b.frontier[:0]
+	for _, name := range b.frontier {
+		if _, ok := f.recipes[name]; ok {
+			b.steps.meet[name] = stats.emit(SearchEvent{Type: "meet", Node: name, Depth: b.depth})
+			continue
+		}
+		open = append(open, name)
+	}
+	b.frontier = open
+}
+
+// bidirectionalTrees returns up to count distinct recipe trees for target
+// that contain every element of require. With deterministic the two sides take turns instead of running
+// concurrently, which only changes the order steps are reported in.
+//
+// When ctx is done the search returns its error. When one of limits is hit
+// the trees built so far are returned together with a *Truncation.
+func bidirectionalTrees(ctx context.Context, g *graph.RecipeGraph, target string, count int, require []string, limits searchLimits, deterministic bool, stats *SearchStats) ([]*tree, searchCounts, error) {
+	requested := count
+	require = slices.Compact(slices.Sorted(slices.Values(require)))
+	if limits.recipes > 0 {
+		count = min(count, limits.recipes)
+	}
+
+	forward := &forwardSide{
+		recipes:  make(map[string][]graph.Pair),
+		frontier: g.BaseElements(),
+		steps:    newSideSteps(),
+	}
+	for _, name := range forward.frontier {
+		forward.recipes[name] = nil
+	}
+	backward := &backwardSide{
+		recipes: make(map[string][]graph.Pair),
+		queued:  map[string]bool{target: true},
+		steps:   newSideSteps(),
+	}
+	if !g.IsBase(target) {
+		backward.frontier = []string{target}
+	}
+
+	counts := searchCounts{}
+	for len(backward.frontier) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, counts, err
+		}
+		counts.maxFrontier = max(counts.maxFrontier, len(forward.frontier)+len(backward.frontier))
+
+		if deterministic {
+			forward.round(g, stats)
+			backward.round(g, stats)
+		} else {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				forward.round(g, stats)
+			}()
+			go func() {
+				defer wg.Done()
+				backward.round(g, stats)
+			}()
+			wg.Wait()
+		}
+		backward.meet(forward, stats)
+	}
+	counts.expanded = forward.counts.expanded + backward.counts.expanded
+
+	// Above the meeting line the backward side knows the recipes, below it
+	// the forward side
+	recipesOf := func(name string) []graph.Pair {
+		if pairs, ok := backward.recipes[name]; ok {
+			return pairs
+		}
+		return forward.recipes[name]
+	}
+
+	// stepsOf returns the steps that made name with pair: either the backward
+	// side expanded name and found the recipe, or the forward side found it
+	// while expanding the ingredients, and the sides may have met at name
+	stepsOf := func(name string, pair graph.Pair) []int {
+		if _, ok := backward.recipes[name]; ok {
+			return slices.Concat(stepOf(backward.steps.expand, name), stepOf(backward.steps.recipe, recipeStep{name, pair}))
+		}
+		return slices.Concat(
+			stepOf(forward.steps.recipe, recipeStep{name, pair}),
+			stepOf(forward.steps.expand, pair.First),
+			stepOf(forward.steps.expand, pair.Second),
+			stepOf(backward.steps.meet, name),
+		)
+	}
+
+	// shapes identifies every tree built regardless of the order of the
+	// ingredients, which is only needed to drop repeats with require
+	shapes := make(map[*tree]string)
+	shapeOf := func(node *tree) string {
+		if len(node.children) == 0 {
+			return node.now
+		}
+		return shapes[node]
+	}
+
+	// treesOf returns up to count distinct trees for name that contain every
+	// element of need. Trees are shared between the elements using them, and
+	// only copied for the answer.
+	type treesKey struct{ name, need string }
+	var truncation *Truncation
+	built := 0
+	memo := make(map[treesKey][]*tree)
+	var treesOf func(name string, need []string) []*tree
+	treesOf = func(name string, need []string) []*tree {
+		key := treesKey{name, strings.Join(need, "\n")}
+		if trees, ok := memo[key]; ok {
+			return trees
+		}
+		need = slices.DeleteFunc(slices.Clone(need), func(required string) bool { return required == name })
+		trees := make([]*tree, 0, 1)
+		if g.IsBase(name) && len(need) == 0 {
+			trees = append(trees, &tree{now: name})
+		}
+
+		seen := make(map[string]bool)
+	recipes:
+		for _, pair := range recipesOf(name) {
+			// Bit i of split sends need[i] to the first ingredient
+			for split := 0; split < 1<<len(need); split++ {
+				first, second := make([]string, 0), make([]string, 0)
+				for i, required := range need {
+					if split&(1<<i) != 0 {
+						first = append(first, required)
+					} else {
+						second = append(second, required)
+					}
+				}
+
+				lefts, rights := treesOf(pair.First, first), treesOf(pair.Second, second)
+				for i, left := range lefts {
+					for j, right := range rights {
+						// A + A only needs one order of every two subtrees
+						if pair.First == pair.Second && len(need) == 0 && j < i {
+							continue
+						}
+						if len(trees) == count || truncation != nil {
+							break recipes
+						}
+
+						shape := ""
+						if len(require) > 0 {
+							a, b := shapeOf(left), shapeOf(right)
+							if b < a {
+								a, b = b, a
+							}
+							sum := sha256.Sum256([]byte(name + "(" + a + "," + b + ")"))
+							shape = hex.EncodeToString(sum[:8])
+							if seen[shape] {
+								continue
+							}
+							seen[shape] = true
+						}
+
+						if limits.nodes > 0 && built == limits.nodes {
+							truncation = &Truncation{Limit: "nodes"}
+							break recipes
+						}
+						built++
+						node := &tree{now: name, children: []*tree{left, right}, childCount: 2, steps: stepsOf(name, pair)}
+						if shape != "" {
+							shapes[node] = shape
+						}
+						trees = append(trees, node)
+					}
+				}
+			}
+		}
+		memo[key] = trees
+		return trees
+	}
+
+	roots := make([]*tree, 0, count)
+	treeNodes := 0
+	for _, shape := range treesOf(target, require) {
+		root := cloneTree(shape, nil)
+		size := countNodes(root)
+		if limits.treeNodes > 0 && treeNodes+size > limits.treeNodes {
+			truncation = &Truncation{Limit: "response_size"}
+			break
+		}
+		roots = append(roots, root)
+		treeNodes += size
+	}
+	if truncation == nil && len(roots) == count && count < requested {
+		truncation = &Truncation{Limit: "recipes"}
+	}
+	counts.expanded += built
+
+	if len(roots) == 0 && truncation == nil && len(require) > 0 {
+		return nil, counts, fmt.Errorf("no tier-minimal recipe for %s contains %s; DFS and BFS also follow the other recipes", target, strings.Join(require, ", "))
+	}
+	if len(roots) == 0 && truncation == nil {
+		return nil, counts, fmt.Errorf("no recipe for %s", target)
+	}
+	if truncation != nil {
+		truncation.Requested = requested
+		truncation.Returned = len(roots)
+		truncation.Expanded = counts.expanded
+		truncation.TreeNodes = treeNodes
+		return roots, counts, truncation
+	}
+	return roots, counts, nil
+}
+
+// countNodes returns the number of nodes of a tree
+func countNodes(root *tree) int {
+	nodes := 1
+	for _, child := range root.children {
+		nodes += countNodes(child)
+	}
+	return nodes
+}
+
+// bidirectionalSearch lays out up to count distinct recipe trees for target
+// with every element of require, found by the bidirectional search
+func bidirectionalSearch(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, count int, require []string, limits searchLimits, deterministic bool, stats *SearchStats) ([]RecipeTree, error) {
+	roots, counts, err := bidirectionalTrees(ctx, g, target, max(count, 1), require, limits, deterministic, stats)
+	stats.add(counts)
+	stats.keep(roots...)
+
+	defer stats.addLayout(time.Now())
+	recipes := make([]RecipeTree, 0, len(roots))
+	for _, root := range roots {
+		recipes = append(recipes, layoutRecipeTree(c, root))
+	}
+	return recipes, err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"scraper/graph"
+)
+
+// checkRecipeTree fails t unless every leaf of root is a base element, every
+// other node is made from its children and the tree is as high as the
+// target's tier
+func checkRecipeTree(t *testing.T, g *graph.RecipeGraph, root *tree) {
+	t.Helper()
+
+	height := 0
+	var check func(node *tree, depth int)
+	check = func(node *tree, depth int) {
+		height = max(height, depth)
+		if len(node.children) == 0 {
+			if !g.IsBase(node.now) {
+				t.Errorf("%s: leaf %s is not a base element", root.now, node.now)
+			}
+			return
+		}
+		if len(node.children) != 2 {
+			t.Fatalf("%s: %s has %d children", root.now, node.now, len(node.children))
+		}
+		first, second := node.children[0].now, node.children[1].now
+		if !slices.Contains(g.Recipes(node.now), graph.Pair{First: first, Second: second}) &&
+			!slices.Contains(g.Recipes(node.now), graph.Pair{First: second, Second: first}) {
+			t.Errorf("%s: %s is not made from %s and %s", root.now, node.now, first, second)
+		}
+		for _, child := range node.children {
+			check(child, depth+1)
+		}
+	}
+	check(root, 0)
+
+	if height != g.Tier(root.now) {
+		t.Errorf("%s: tree is %d high, tier is %d", root.now, height, g.Tier(root.now))
+	}
+}
+
+func TestBidirectionalShortest(t *testing.T) {
+	g := loadTestGraph(t)
+
+	for _, target := range g.Elements() {
+		if g.Tier(target) < 0 {
+			continue
+		}
+		for _, deterministic := range []bool{true, false} {
+			roots, _, err := bidirectionalTrees(context.Background(), g, target, 1, nil, searchLimits{}, deterministic, nil)
+			if err != nil {
+				t.Fatalf("%s: %v", target, err)
+			}
+			if len(roots) != 1 {
+				t.Fatalf("%s: %d trees", target, len(roots))
+			}
+			checkRecipeTree(t, g, roots[0])
+		}
+	}
+}
+
+func TestBidirectionalMultiple(t *testing.T) {
+	g := loadTestGraph(t)
+
+	for _, target := range []string{"Brick", "Human", "Airplane", "Dinosaur"} {
+		roots, _, err := bidirectionalTrees(context.Background(), g, target, 50, nil, searchLimits{}, false, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+
+		seen := make(map[string]bool)
+		for _, root := range roots {
+			checkRecipeTree(t, g, root)
+			hash := canonicalHash(root)
+			if seen[hash] {
+				t.Errorf("%s: tree %s returned twice", target, hash)
+			}
+			seen[hash] = true
+		}
+
+		// The same trees as the depth first search without higher tiers
+		want, _, err := enumerateTrees(context.Background(), g, target, 50, false, treeOptions{}, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if len(roots) != len(want) {
+			t.Errorf("%s: %d trees, DFS finds %d", target, len(roots), len(want))
+		}
+	}
+}
+
+func TestBidirectionalLimits(t *testing.T) {
+	g := loadTestGraph(t)
+
+	roots, _, err := bidirectionalTrees(context.Background(), g, "Human", 50, nil, searchLimits{recipes: 10}, true, nil)
+	truncation, ok := err.(*Truncation)
+	if !ok || truncation.Limit != "recipes" || len(roots) != 10 {
+		t.Fatalf("got %d trees and %v, want 10 trees cut at the recipes limit", len(roots), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := bidirectionalTrees(ctx, g, "Human", 1, nil, searchLimits{}, true, nil); err != context.Canceled {
+		t.Fatalf("got %v after cancel, want %v", err, context.Canceled)
+	}
+}
+
+// containsAll reports whether the tree of root has every element of names
+func containsAll(root *tree, names []string) bool {
+	found := make(map[string]bool)
+	var walk func(node *tree)
+	walk = func(node *tree) {
+		found[node.now] = true
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	for _, name := range names {
+		if !found[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBidirectionalRequire(t *testing.T) {
+	g := loadTestGraph(t)
+
+	all, _, err := bidirectionalTrees(context.Background(), g, "Human", 1000, nil, searchLimits{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requires := [][]string{
+		{"Energy"},
+		{"Continent"},
+		{"Fire", "Pressure"},
+		{"Energy", "Continent"},
+		{"Volcano", "Land", "Time"},
+		{"Human", "Mud"},
+		{"Dragon"},
+		{"Energy", "Pressure"},
+	}
+	for _, require := range requires {
+		want := 0
+		for _, root := range all {
+			if containsAll(root, require) {
+				want++
+			}
+		}
+
+		roots, _, err := bidirectionalTrees(context.Background(), g, "Human", 1000, require, searchLimits{}, true, nil)
+		if want == 0 {
+			if err == nil {
+				t.Errorf("%v: %d trees, want an error", require, len(roots))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", require, err)
+		}
+
+		seen := make(map[string]bool)
+		for _, root := range roots {
+			checkRecipeTree(t, g, root)
+			if !containsAll(root, require) {
+				t.Errorf("%v: tree %s misses a required element", require, canonicalHash(root))
+			}
+			seen[canonicalHash(root)] = true
+		}
+		if len(seen) != len(roots) || len(roots) != want {
+			t.Errorf("%v: %d trees, %d distinct, want %d", require, len(roots), len(seen), want)
+		}
+	}
+
+	// The count is of the trees that have the required elements
+	roots, _, err := bidirectionalTrees(context.Background(), g, "Human", 5, []string{"Energy"}, searchLimits{}, true, nil)
+	if err != nil || len(roots) != 5 {
+		t.Fatalf("got %d trees and %v, want 5 trees", len(roots), err)
+	}
+}
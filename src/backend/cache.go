@@ -0,0 +1,279 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SEARCH_CACHE_SIZE is how many answers the search cache keeps, 0 to keep
+// none. Identical searches running at the same time are shared either way.
+var SEARCH_CACHE_SIZE = 256
+
+// searchCacheSize returns the capacity of the search cache. It can be
+// overridden with SEARCH_CACHE_SIZE; "0" disables caching.
+func searchCacheSize() int {
+	size := SEARCH_CACHE_SIZE
+
+	if env := os.Getenv("SEARCH_CACHE_SIZE"); env != "" {
+		parsed, err := strconv.Atoi(env)
+		if err != nil || parsed < 0 {
+			fmt.Printf("Invalid SEARCH_CACHE_SIZE %q, using %d\n", env, size)
+			return size
+		}
+		size = parsed
+	}
+
+	return size
+}
+
+/*	Search cache
+*
+*	Answers are kept in a least recently used list, keyed by everything that
+*	goes into them: the dataset version, the resolved request and the
+*	options the search actually runs with. Only deterministic searches are
+*	cached, since anything else is expected to vary, and only complete
+*	answers: a search cut short by its deadline may finish next time.
+*
+*	A search that is already running for the same key is joined instead of
+*	started again. It runs detached from the request that started it and is
+*	only canceled once every request waiting for it is gone.
+ */
+type searchCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element // key -> element of order
+	order    *list.List               // cached answers, most recently used first
+	flights  map[string]*searchFlight // searches running right now
+
+	hits      int // answers served from the cache
+	misses    int // searches started
+	shared    int // requests that joined a running search
+	evictions int // answers dropped to make room
+}
+
+// cachedSearch is an answer in the cache
+type cachedSearch struct {
+	key      string
+	response *Response
+}
+
+// searchFlight is a search that requests are waiting for
+type searchFlight struct {
+	done     chan struct{} // closed once response or failure is set
+	response *Response
+	failure  *searchFailure
+	waiters  int
+	cancel   context.CancelFunc
+}
+
+// searchResults caches the answers of /api
+var searchResults = newSearchCache(SEARCH_CACHE_SIZE)
+
+func newSearchCache(capacity int) *searchCache {
+	return &searchCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		flights:  make(map[string]*searchFlight),
+	}
+}
+
+// searchKey identifies the answer to req, which runs with the options in
+// stats. Image links carry the host the request was sent to, so it is part
+// of the key as well.
+func searchKey(c *gin.Context, req *searchRequest, stats *SearchStats) string {
+	inventory := slices.Clone(req.inventory)
+	slices.Sort(inventory)
+	require := slices.Clone(req.require)
+	slices.Sort(require)
+	options := stats.Options
+	options.Exclude = slices.Clone(options.Exclude)
+	slices.Sort(options.Exclude)
+
+	key, _ := json.Marshal(struct {
+		Version     int
+		Host        string
+		TLS         bool
+		Target      string
+		Method      string
+		Options     StatsOptions
+		Base        []string
+		Inventory   []string
+		Require     []string
+		Costs       map[string]float64
+		RecipeCosts map[string]float64
+		Trace       bool
+	}{
+		Version:     req.version,
+		Host:        c.Request.Host,
+		TLS:         c.Request.TLS != nil,
+		Target:      req.target,
+		Method:      stats.Method,
+		Options:     options,
+		Base:        req.graph.BaseElements(),
+		Inventory:   inventory,
+		Require:     require,
+		Costs:       req.Costs,
+		RecipeCosts: req.RecipeCosts,
+		Trace:       req.Trace,
+	})
+	return string(key)
+}
+
+// search answers the request in c for target from the cache, by joining the
+// search running for key, or by running search itself
+func (s *searchCache) search(c *gin.Context, key string, target string, search func(ctx context.Context) (*Response, *searchFailure)) (*Response, *searchFailure) {
+	s.mu.Lock()
+	if element, ok := s.entries[key]; ok {
+		s.order.MoveToFront(element)
+		s.hits++
+		s.mu.Unlock()
+
+		// The cached answer is shared, so only a copy is marked
+		hit := *element.Value.(*cachedSearch).response
+		hit.Cached = true
+		return &hit, nil
+	}
+
+	if flight, ok := s.flights[key]; ok {
+		flight.waiters++
+		s.shared++
+		s.mu.Unlock()
+
+		ctx, cancel := searchContext(c)
+		defer cancel()
+		select {
+		case <-flight.done:
+			return flight.response, flight.failure
+		case <-ctx.Done():
+			s.leave(key, flight)
+			return nil, searchFailed(target, ctx.Err())
+		}
+	}
+
+	// The search outlives this request as long as others wait for it, but
+	// not its own deadline
+	ctx, cancel := withSearchTimeout(context.WithoutCancel(c.Request.Context()))
+	flight := &searchFlight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	s.flights[key] = flight
+	s.misses++
+	s.mu.Unlock()
+
+	stop := context.AfterFunc(c.Request.Context(), func() {
+		s.leave(key, flight)
+	})
+
+	// The flight is settled even when search panics, or every request for
+	// key would wait for it forever
+	finished := false
+	defer func() {
+		stop()
+		cancel()
+		if !finished {
+			flight.response, flight.failure = nil, &searchFailure{http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("search for %s failed", target),
+			}}
+		}
+
+		s.mu.Lock()
+		if s.flights[key] == flight {
+			delete(s.flights, key)
+		}
+		if flight.failure == nil && !flight.response.Partial {
+			s.add(key, flight.response)
+		}
+		s.mu.Unlock()
+		close(flight.done)
+	}()
+
+	flight.response, flight.failure = search(ctx)
+	finished = true
+	return flight.response, flight.failure
+}
+
+// leave records that a request stopped waiting for flight, canceling it once
+// nobody waits anymore. Later requests for key start over.
+func (s *searchCache) leave(key string, flight *searchFlight) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flight.waiters--
+	if flight.waiters > 0 {
+		return
+	}
+	flight.cancel()
+	if s.flights[key] == flight {
+		delete(s.flights, key)
+	}
+}
+
+// add caches response under key, evicting the least recently used answers
+// beyond the capacity. s.mu must be held.
+func (s *searchCache) add(key string, response *Response) {
+	if s.capacity == 0 {
+		return
+	}
+	if element, ok := s.entries[key]; ok {
+		element.Value.(*cachedSearch).response = response
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(&cachedSearch{key: key, response: response})
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cachedSearch).key)
+		s.evictions++
+	}
+}
+
+// purge drops every cached answer, for when the dataset they were computed
+// from is replaced
+func (s *searchCache) purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make(map[string]*list.Element)
+	s.order.Init()
+}
+
+// CacheStats is the state of the search cache
+type CacheStats struct {
+	Entries   int `json:"entries"`
+	Capacity  int `json:"capacity"`
+	Hits      int `json:"hits"`      // Answers served from the cache
+	Misses    int `json:"misses"`    // Searches started
+	Shared    int `json:"shared"`    // Requests that joined an identical running search
+	Evictions int `json:"evictions"` // Answers dropped to make room
+	Running   int `json:"running"`   // Searches running right now
+}
+
+func (s *searchCache) stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return CacheStats{
+		Entries:   s.order.Len(),
+		Capacity:  s.capacity,
+		Hits:      s.hits,
+		Misses:    s.misses,
+		Shared:    s.shared,
+		Evictions: s.evictions,
+		Running:   len(s.flights),
+	}
+}
+
+// handleCacheStats reports the hit and miss counters of the search cache
+func handleCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, searchResults.stats())
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testContext returns a request context whose client goes away on cancel
+func testContext() (*gin.Context, context.CancelFunc) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api", nil).WithContext(ctx)
+	return c, cancel
+}
+
+// answer is a search that finds nothing to wait for
+func answer(ctx context.Context) (*Response, *searchFailure) {
+	return &Response{}, nil
+}
+
+// waitFor fails t unless done reports true within a second
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !done(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSearchCacheHit(t *testing.T) {
+	cache := newSearchCache(4)
+	c, cancel := testContext()
+	defer cancel()
+
+	if response, _ := cache.search(c, "key", "Human", answer); response.Cached {
+		t.Fatal("first answer is marked cached")
+	}
+	response, failure := cache.search(c, "key", "Human", func(ctx context.Context) (*Response, *searchFailure) {
+		t.Fatal("searched again for a cached answer")
+		return nil, nil
+	})
+	if failure != nil || !response.Cached {
+		t.Fatalf("got %+v and %+v, want a cached answer", response, failure)
+	}
+	if stats := cache.stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("got %+v, want one hit and one miss", stats)
+	}
+
+	// Reloading drops every answer
+	cache.purge()
+	if response, _ := cache.search(c, "key", "Human", answer); response.Cached {
+		t.Fatal("answer survived the purge")
+	}
+}
+
+func TestSearchCacheShared(t *testing.T) {
+	cache := newSearchCache(4)
+	release := make(chan struct{})
+	searches := 0
+	search := func(ctx context.Context) (*Response, *searchFailure) {
+		searches++
+		<-release
+		return &Response{}, nil
+	}
+
+	responses := make(chan *Response, 2)
+	for range 2 {
+		c, cancel := testContext()
+		defer cancel()
+		go func() {
+			response, _ := cache.search(c, "key", "Human", search)
+			responses <- response
+		}()
+		waitFor(t, "the search to start", func() bool { return cache.stats().Running == 1 })
+	}
+	waitFor(t, "the second request to join", func() bool { return cache.stats().Shared == 1 })
+
+	close(release)
+	first, second := <-responses, <-responses
+	if first != second || searches != 1 {
+		t.Fatalf("got %d searches for two requests, want one shared search", searches)
+	}
+}
+
+func TestSearchCacheLeave(t *testing.T) {
+	cache := newSearchCache(4)
+	canceled := make(chan struct{})
+	search := func(ctx context.Context) (*Response, *searchFailure) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, searchFailed("Human", ctx.Err())
+	}
+
+	leader, cancelLeader := testContext()
+	waiter, cancelWaiter := testContext()
+	failures := make(chan *searchFailure, 2)
+	go func() {
+		_, failure := cache.search(leader, "key", "Human", search)
+		failures <- failure
+	}()
+	waitFor(t, "the search to start", func() bool { return cache.stats().Running == 1 })
+	go func() {
+		_, failure := cache.search(waiter, "key", "Human", search)
+		failures <- failure
+	}()
+	waitFor(t, "the waiter to join", func() bool { return cache.stats().Shared == 1 })
+
+	// The search goes on for the waiter after the leader is gone
+	cancelLeader()
+	select {
+	case <-canceled:
+		t.Fatal("search canceled while a request still waits for it")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancelWaiter()
+	<-canceled
+	for range 2 {
+		if failure := <-failures; failure == nil || failure.status != STATUS_CLIENT_CLOSED_REQUEST {
+			t.Fatalf("got %+v, want the client closed status", failure)
+		}
+	}
+	if stats := cache.stats(); stats.Running != 0 || stats.Entries != 0 {
+		t.Fatalf("got %+v, want no search running and nothing cached", stats)
+	}
+}
+
+func TestSearchCachePanic(t *testing.T) {
+	cache := newSearchCache(4)
+	release := make(chan struct{})
+	search := func(ctx context.Context) (*Response, *searchFailure) {
+		<-release
+		panic("search failed")
+	}
+
+	leader, cancelLeader := testContext()
+	defer cancelLeader()
+	go func() {
+		defer func() { recover() }()
+		cache.search(leader, "key", "Human", search)
+	}()
+	waitFor(t, "the search to start", func() bool { return cache.stats().Running == 1 })
+
+	waiter, cancelWaiter := testContext()
+	defer cancelWaiter()
+	failures := make(chan *searchFailure, 1)
+	go func() {
+		_, failure := cache.search(waiter, "key", "Human", search)
+		failures <- failure
+	}()
+	waitFor(t, "the waiter to join", func() bool { return cache.stats().Shared == 1 })
+
+	close(release)
+	if failure := <-failures; failure == nil || failure.status != http.StatusInternalServerError {
+		t.Fatalf("got %+v, want an internal error", failure)
+	}
+	if stats := cache.stats(); stats.Running != 0 {
+		t.Fatalf("got %+v, want the failed search gone", stats)
+	}
+}
+
+func TestSearchCacheEviction(t *testing.T) {
+	cache := newSearchCache(2)
+	c, cancel := testContext()
+	defer cancel()
+
+	cache.search(c, "a", "Human", answer)
+	cache.search(c, "b", "Human", answer)
+	cache.search(c, "a", "Human", answer) // a is now used more recently than b
+	cache.search(c, "c", "Human", answer)
+
+	if stats := cache.stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Fatalf("got %+v, want two answers after one eviction", stats)
+	}
+	for _, key := range []string{"a", "c"} {
+		if response, _ := cache.search(c, key, "Human", answer); !response.Cached {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if response, _ := cache.search(c, "b", "Human", answer); response.Cached {
+		t.Error("b was kept over more recently used answers")
+	}
+}
+
+func TestSearchKeyOrder(t *testing.T) {
+	g := loadTestGraph(t)
+	c, cancel := testContext()
+	defer cancel()
+
+	key := func(names ...string) string {
+		req := &searchRequest{target: "Human", graph: g, inventory: names, require: names}
+		stats := &SearchStats{Method: "DFS", Options: StatsOptions{Exclude: names}}
+		return searchKey(c, req, stats)
+	}
+	if key("Life", "Fire") != key("Fire", "Life") {
+		t.Fatal("the order of the listed elements changes the key")
+	}
+	if key("Life") == key("Fire") {
+		t.Fatal("different elements give the same key")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CostTree is the tree found by the Cost method together with its price
+type CostTree struct {
+	RecipeTree
+	Cost float64
+}
+
+// costModel turns the objective of a request into a cost model, resolving the
+// names in its cost maps. When it returns false the request has already been
+// answered with an error.
+func costModel(c *gin.Context, resolver *graph.Resolver, data requestData) (graph.CostModel, bool) {
+	fail := func(format string, args ...any) (graph.CostModel, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(format, args...)})
+		return graph.CostModel{}, false
+	}
+
+	objective := strings.ToLower(strings.TrimSpace(data.Objective))
+	if objective != "cost" && (len(data.Costs) > 0 || len(data.RecipeCosts) > 0) {
+		return fail("costs need the cost objective")
+	}
+
+	switch objective {
+	case "", "combinations":
+		return graph.CombinationsCost, true
+	case "leaves":
+		return graph.LeavesCost, true
+	case "cost":
+	default:
+		return fail("unknown objective %q, expected combinations, leaves or cost", data.Objective)
+	}
+
+	// Unpriced combinations cost one, so an empty map counts combinations
+	model := graph.CostModel{
+		Recipe:   1,
+		Elements: make(map[string]float64),
+		Recipes:  make(map[graph.Pair]float64),
+	}
+
+	for name, price := range data.Costs {
+		element, _, ok := resolver.Resolve(name)
+		if !ok {
+			return fail("cost element %q not found", name)
+		}
+		model.Elements[element] = price
+	}
+
+	for recipe, price := range data.RecipeCosts {
+		first, second, found := strings.Cut(recipe, "+")
+		if !found {
+			return fail("recipe %q should look like \"First + Second\"", recipe)
+		}
+
+		pair := graph.Pair{}
+		var ok bool
+		if pair.First, _, ok = resolver.Resolve(first); !ok {
+			return fail("recipe element %q not found", strings.TrimSpace(first))
+		}
+		if pair.Second, _, ok = resolver.Resolve(second); !ok {
+			return fail("recipe element %q not found", strings.TrimSpace(second))
+		}
+		if pair.Second < pair.First {
+			pair.First, pair.Second = pair.Second, pair.First
+		}
+		model.Recipes[pair] = price
+	}
+
+	if err := model.Validate(); err != nil {
+		return fail("%s", err.Error())
+	}
+	return model, true
+}
+
+// costSearch finds the cheapest tree for target within maxDepth levels and
+// lays it out. Settling the costs takes polynomial time and cannot be
+// interrupted, so without maxDepth ctx is only checked once it is done; the
+// costs within maxDepth check it between levels.
+func costSearch(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, model graph.CostModel, maxDepth int, stats *SearchStats) (*CostTree, error) {
+	cheapest, err := g.CheapestTree(ctx, target, model, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	stats.add(searchCounts{expanded: cheapest.Settled, maxFrontier: cheapest.MaxQueue})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var build func(name string, depth int, parent *tree) *tree
+	build = func(name string, depth int, parent *tree) *tree {
+		node := &tree{now: name, depth: depth, parent: parent}
+		if pair, ok := cheapest.Recipe(name, depth); ok {
+			node.children = []*tree{
+				build(pair.First, depth+1, node),
+				build(pair.Second, depth+1, node),
+			}
+			node.childCount = 2
+		}
+		return node
+	}
+
+	defer stats.addLayout(time.Now())
+	return &CostTree{
+		RecipeTree: layoutRecipeTree(c, build(target, 0, nil)),
+		Cost:       cheapest.Cost,
+	}, nil
+}
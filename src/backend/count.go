@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CountResponse struct {
+	Target string `json:"target"`
+	Count  string `json:"count"`  // Decimal, since it easily exceeds a JSON number
+	Digits int    `json:"digits"` // Length of Count, for quick display
+}
+
+// handleCount answers POST /api/count with the number of distinct full recipe
+// trees for the target, so users know how many recipes there are to ask for
+func handleCount(c *gin.Context) {
+	req, ok := bindSearchRequest(c)
+	if !ok {
+		return
+	}
+
+	// The include_higher searches never repeat an ancestor below itself, so
+	// what they can enumerate depends on the whole path above every node
+	// and has no count per element
+	if req.IncludeHigher {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "counting does not support include_higher"})
+		return
+	}
+
+	// The counts are kept per element for the whole graph, and which trees
+	// contain an element is a question about every path below the target
+	if len(req.require) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "counting does not support require"})
+		return
+	}
+
+	// Every counted tree only uses recipes that lower the tier, so none is
+	// higher than the target's tier and the depth check settles max_depth
+	if failure := checkSearch(req); failure != nil {
+		failure.answer(c)
+		return
+	}
+
+	count := req.graph.CountTrees(req.target)
+	response := CountResponse{
+		Target: req.target,
+		Count:  count.String(),
+	}
+	response.Digits = len(response.Count)
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCountTreesMatchesEnumeration(t *testing.T) {
+	g := loadTestGraph(t)
+
+	// Every element with few enough trees to enumerate them all
+	for _, target := range g.Elements() {
+		count := g.CountTrees(target)
+		if !count.IsInt64() || count.Int64() > 700 {
+			continue
+		}
+
+		trees, _, err := enumerateTrees(context.Background(), g, target, int(count.Int64())+1, false, treeOptions{}, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if int64(len(trees)) != count.Int64() {
+			t.Errorf("%s: counted %s trees, DFS enumerates %d", target, count, len(trees))
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"scraper/graph"
+)
+
+// SEARCH_WORKERS is how many goroutines expand the nodes of one search
+var SEARCH_WORKERS = runtime.GOMAXPROCS(0)
+
+// searchWorkers returns the size of the worker pool. It can be overridden
+// with SEARCH_WORKERS; it defaults to GOMAXPROCS.
+func searchWorkers() int {
+	workers := runtime.GOMAXPROCS(0)
+
+	if env := os.Getenv("SEARCH_WORKERS"); env != "" {
+		parsed, err := strconv.Atoi(env)
+		if err != nil || parsed < 1 {
+			fmt.Printf("Invalid SEARCH_WORKERS %q, using %d\n", env, workers)
+			return workers
+		}
+		workers = parsed
+	}
+
+	return workers
+}
+
+/*	Expansion engine
+*
+*	A fixed pool of workers shares one frontier. A worker takes a node, asks
+*	expand for its children, and puts them back into the frontier for any
+*	worker to take, so nothing ever waits for a batch to finish. The frontier
+*	is a stack for depth first searches and a queue for breadth first ones.
+*	The search ends when no node is queued and none is being expanded, or
+*	when the context is done.
+*
+*	Each node is expanded by exactly one worker, so expand may freely modify
+*	the node it is given and the children it creates.
+ */
+type expansionEngine struct {
+	workers    int
+	depthFirst bool
+	stats      *SearchStats // receives the steps, may be nil
+}
+
+// frontier is the concurrent stack or queue of nodes waiting for a worker
+type frontier struct {
+	mu         sync.Mutex
+	ready      *sync.Cond
+	nodes      []*tree
+	depthFirst bool
+	pending    int  // nodes queued or being expanded
+	closed     bool // the context is done
+	maxSize    int  // most nodes queued at once
+}
+
+// take returns the next node to expand and how many are left, waiting while
+// other workers may still add some. It returns false once the search is over.
+func (f *frontier) take() (*tree, int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.nodes) == 0 && f.pending > 0 && !f.closed {
+		f.ready.Wait()
+	}
+	if f.closed || len(f.nodes) == 0 {
+		return nil, 0, false
+	}
+
+	var node *tree
+	if f.depthFirst {
+		node = f.nodes[len(f.nodes)-1]
+		f.nodes = f.nodes[:len(f.nodes)-1]
+	} else {
+		node = f.nodes[0]
+		f.nodes = f.nodes[1:]
+	}
+	return node, len(f.nodes), true
+}
+
+// finish records that a node has been expanded into children
+func (f *frontier) finish(children []*tree) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nodes = append(f.nodes, children...)
+	f.pending += len(children) - 1
+	f.maxSize = max(f.maxSize, len(f.nodes))
+
+	if f.pending == 0 {
+		f.ready.Broadcast() // wake everyone up to leave
+	} else {
+		for range children {
+			f.ready.Signal()
+		}
+	}
+}
+
+// close ends the search early
+func (f *frontier) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	f.ready.Broadcast()
+}
+
+// run expands the tree below root and returns the work it took. Nodes get
+// their id in the order they are taken, which depends on scheduling as soon
+// as there is more than one worker.
+func (e expansionEngine) run(ctx context.Context, root *tree, expand func(node *tree) []*tree) (searchCounts, error) {
+	f := &frontier{
+		nodes:      []*tree{root},
+		depthFirst: e.depthFirst,
+		pending:    1,
+		maxSize:    1,
+	}
+	f.ready = sync.NewCond(&f.mu)
+
+	stop := context.AfterFunc(ctx, f.close)
+	defer stop()
+
+	var expanded atomic.Int64
+	var wg sync.WaitGroup
+	for range max(e.workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				node, waiting, ok := f.take()
+				if !ok {
+					return
+				}
+				node.id = int(expanded.Add(1) - 1)
+				node.steps = append(node.steps, e.stats.emitExpand(node.now, parentName(node), node.depth, waiting))
+
+				children := expand(node)
+				if len(node.children) == 2 {
+					node.steps = append(node.steps, e.stats.emitRecipe(node.now, parentName(node), node.depth, graph.Pair{First: node.children[0].now, Second: node.children[1].now}))
+				}
+				f.finish(children)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := searchCounts{expanded: int(expanded.Load()), maxFrontier: f.maxSize}
+	return counts, ctx.Err()
+}
+
+// numberInOrder renumbers the nodes of a tree in the order a single worker
+// takes them: in preorder for depth first searches, which push the right
+// child below the left one, and level by level for breadth first ones.
+func numberInOrder(root *tree, depthFirst bool) {
+	id := 0
+	if depthFirst {
+		var number func(node *tree)
+		number = func(node *tree) {
+			node.id = id
+			id++
+			for _, child := range node.children {
+				number(child)
+			}
+		}
+		number(root)
+		return
+	}
+
+	queue := []*tree{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		node.id = id
+		id++
+		queue = append(queue, node.children...)
+	}
+}
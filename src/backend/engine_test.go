@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+
+	"scraper/graph"
+)
+
+// loadTestGraph loads the full Little Alchemy 2 dataset from the data files
+func loadTestGraph(tb testing.TB) *graph.RecipeGraph {
+	tb.Helper()
+
+	base, err := graph.LoadBaseElements(BASE_PATH)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	g, err := graph.Load(RECIPES_PATH, IMAGES_PATH, base)
+	if err != nil {
+		tb.Skipf("dataset not available: %v", err)
+	}
+	return g
+}
+
+// batchExpand is the expansion the engine replaced: up to four nodes are
+// taken at a time, each on its own goroutine, and the next batch waits for
+// all of them
+func batchExpand(root *tree, depthFirst bool, expand func(node *tree) []*tree) int {
+	var mu sync.Mutex
+	nodes := []*tree{root}
+	expanded := 0
+
+	for len(nodes) > 0 {
+		size := min(4, len(nodes))
+		var batch []*tree
+		if depthFirst {
+			batch = append(batch, nodes[len(nodes)-size:]...)
+			nodes = nodes[:len(nodes)-size]
+		} else {
+			batch = nodes[:size]
+			nodes = nodes[size:]
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(len(batch))
+		for _, node := range batch {
+			go func(n *tree) {
+				defer wg.Done()
+				children := expand(n)
+
+				mu.Lock()
+				n.id = expanded
+				expanded++
+				nodes = append(nodes, children...)
+				mu.Unlock()
+			}(node)
+		}
+		wg.Wait()
+	}
+	return expanded
+}
+
+// benchmarkShortest builds the shortest tree of every reachable element of
+// the dataset once per iteration and reports the nodes expanded per second
+func benchmarkShortest(b *testing.B, depthFirst bool) {
+	g := loadTestGraph(b)
+	targets := make([]string, 0)
+	for _, name := range g.Elements() {
+		if g.Tier(name) > 0 {
+			targets = append(targets, name)
+		}
+	}
+
+	step := shortestBFSStep(g)
+	if depthFirst {
+		step = shortestDFSStep(g)
+	}
+
+	run := func(b *testing.B, expand func(root *tree) int) {
+		nodes := 0
+		for b.Loop() {
+			for _, target := range targets {
+				nodes += expand(&tree{now: target})
+			}
+		}
+		b.ReportMetric(float64(nodes)/b.Elapsed().Seconds(), "nodes/s")
+		b.ReportMetric(float64(nodes)/float64(b.N)/float64(len(targets)), "nodes/tree")
+	}
+
+	b.Run("batch=4", func(b *testing.B) {
+		run(b, func(root *tree) int {
+			return batchExpand(root, depthFirst, step)
+		})
+	})
+
+	counts := []int{1, 2, 4, runtime.GOMAXPROCS(0)}
+	slices.Sort(counts)
+	for _, workers := range slices.Compact(counts) {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			engine := expansionEngine{workers: workers, depthFirst: depthFirst}
+			run(b, func(root *tree) int {
+				counts, err := engine.run(context.Background(), root, step)
+				if err != nil {
+					b.Fatal(err)
+				}
+				return counts.expanded
+			})
+		})
+	}
+}
+
+func BenchmarkShortestDFS(b *testing.B) {
+	benchmarkShortest(b, true)
+}
+
+func BenchmarkShortestBFS(b *testing.B) {
+	benchmarkShortest(b, false)
+}
@@ -0,0 +1,372 @@
+package graph
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+)
+
+// CostModel prices a recipe tree as the sum of the prices of its nodes: every
+// base element leaf costs its leaf price and every combination costs its
+// recipe price. Prices must not be negative.
+type CostModel struct {
+	Leaf     float64            // Price of a base element leaf without an entry in Elements
+	Recipe   float64            // Price of a combination without an entry in Recipes or Elements
+	Elements map[string]float64 // Leaf price of a base element, or the price of any combination making a crafted one
+	Recipes  map[Pair]float64   // Price of one recipe of any result, ingredients in sorted order; beats Elements
+}
+
+// CombinationsCost counts the combinations of a tree
+var CombinationsCost = CostModel{Recipe: 1}
+
+// LeavesCost counts the base element leaves of a tree
+var LeavesCost = CostModel{Leaf: 1}
+
+// Validate reports the first negative price of the model
+func (m CostModel) Validate() error {
+	if m.Leaf < 0 || m.Recipe < 0 {
+		return fmt.Errorf("costs cannot be negative")
+	}
+	for name, price := range m.Elements {
+		if price < 0 {
+			return fmt.Errorf("cost of %s cannot be negative", name)
+		}
+	}
+	for pair, price := range m.Recipes {
+		if price < 0 {
+			return fmt.Errorf("cost of %s + %s cannot be negative", pair.First, pair.Second)
+		}
+	}
+	return nil
+}
+
+func (m CostModel) leafPrice(name string) float64 {
+	if price, ok := m.Elements[name]; ok {
+		return price
+	}
+	return m.Leaf
+}
+
+func (m CostModel) recipePrice(result string, pair Pair) float64 {
+	if pair.Second < pair.First {
+		pair.First, pair.Second = pair.Second, pair.First
+	}
+	if price, ok := m.Recipes[pair]; ok {
+		return price
+	}
+	if price, ok := m.Elements[result]; ok {
+		return price
+	}
+	return m.Recipe
+}
+
+// CostTree is the cheapest full recipe tree of an element. Without a depth
+// limit every occurrence of an element in the tree is made with the same
+// recipe; with one, the recipe may also depend on how many levels are left
+// below the occurrence.
+type CostTree struct {
+	Target   string
+	Cost     float64
+	Settled  int // elements priced, once per number of levels left when depth limited
+	MaxQueue int // most tentative prices queued at once, 0 when depth limited
+	maxDepth int
+	recipes  map[costKey]Pair
+}
+
+// costKey is an element with the levels left below it, 0 when not limited
+type costKey struct {
+	name   string
+	levels int
+}
+
+// Recipe returns the recipe used for name when it appears depth levels below
+// the target, and false for the leaves
+func (t *CostTree) Recipe(name string, depth int) (Pair, bool) {
+	levels := 0
+	if t.maxDepth > 0 {
+		levels = t.maxDepth - depth
+	}
+	pair, ok := t.recipes[costKey{name, levels}]
+	return pair, ok
+}
+
+// costItem is a tentative price for making an element with one recipe.
+type costItem struct {
+	cost   float64
+	name   string
+	recipe int // index into the recipe index, -1 for a base element leaf
+}
+
+// costQueue is a min-heap of costItems ordered by cost, then by name and
+// recipe so ties are always broken the same way.
+type costQueue []costItem
+
+func (q costQueue) Len() int { return len(q) }
+func (q costQueue) Less(i, j int) bool {
+	if q[i].cost != q[j].cost {
+		return q[i].cost < q[j].cost
+	}
+	if q[i].name != q[j].name {
+		return q[i].name < q[j].name
+	}
+	return q[i].recipe < q[j].recipe
+}
+func (q costQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *costQueue) Push(x any)   { *q = append(*q, x.(costItem)) }
+func (q *costQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// CheapestTree returns the full recipe tree of target with the lowest price
+// under model whose leaves are at most maxDepth levels below the target, or
+// at any depth when maxDepth is 0. The price of a tree is its recipe price
+// plus the prices of both subtrees; with non-negative prices that is a
+// superior function just like the tier, so Knuth's algorithm settles elements
+// in increasing price order and the first recipe an element is settled with
+// is provably the cheapest. Base elements are always leaves, even when a
+// recipe could make them. The search within maxDepth returns the error of ctx
+// once it is done.
+func (g *RecipeGraph) CheapestTree(ctx context.Context, target string, model CostModel, maxDepth int) (*CostTree, error) {
+	if err := model.Validate(); err != nil {
+		return nil, err
+	}
+	if g.Tier(target) == -1 {
+		return nil, fmt.Errorf("%s cannot be made from the base elements", target)
+	}
+	if maxDepth > 0 {
+		if g.Tier(target) > maxDepth {
+			return nil, fmt.Errorf("no recipe for %s within depth %d", target, maxDepth)
+		}
+		return g.cheapestWithin(ctx, target, model, maxDepth)
+	}
+
+	costs, chosen, work := g.settleCosts(model, target)
+	index := g.index
+
+	tree := &CostTree{
+		Target:   target,
+		Cost:     costs[index.id[target]],
+		Settled:  work.settled,
+		MaxQueue: work.maxQueue,
+		recipes:  make(map[costKey]Pair),
+	}
+
+	// Ingredients are always settled before their result, so following the
+	// chosen recipes down from the target cannot loop
+	var collect func(id int)
+	collect = func(id int) {
+		recipe := chosen[id]
+		if recipe == -1 {
+			return
+		}
+		key := costKey{g.elements[id], 0}
+		if _, ok := tree.recipes[key]; ok {
+			return
+		}
+		first, second := index.firsts[recipe], index.seconds[recipe]
+		tree.recipes[key] = Pair{g.elements[first], g.elements[second]}
+		collect(first)
+		collect(second)
+	}
+	collect(index.id[target])
+
+	return tree, nil
+}
+
+// cheapestWithin finds the cheapest tree for target within maxDepth levels,
+// which the target must fit in
+func (g *RecipeGraph) cheapestWithin(ctx context.Context, target string, model CostModel, maxDepth int) (*CostTree, error) {
+	costs, chosen, err := g.layeredCosts(ctx, model, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	index := g.index
+
+	tree := &CostTree{
+		Target:   target,
+		Cost:     costs[maxDepth][index.id[target]],
+		maxDepth: maxDepth,
+		recipes:  make(map[costKey]Pair),
+	}
+	for _, layer := range costs {
+		for _, cost := range layer {
+			if cost != -1 {
+				tree.Settled++
+			}
+		}
+	}
+
+	var collect func(id int, levels int)
+	collect = func(id int, levels int) {
+		recipe := chosen[levels][id]
+		if recipe == -1 {
+			return
+		}
+		key := costKey{g.elements[id], levels}
+		if _, ok := tree.recipes[key]; ok {
+			return
+		}
+		first, second := index.firsts[recipe], index.seconds[recipe]
+		tree.recipes[key] = Pair{g.elements[first], g.elements[second]}
+		collect(first, levels-1)
+		collect(second, levels-1)
+	}
+	collect(index.id[target], maxDepth)
+
+	return tree, nil
+}
+
+// layeredCosts prices every element for every number of levels left, from
+// the leaves up: with h levels an element is either a base element leaf or
+// made by a recipe whose ingredients both fit in h - 1 levels. Prices are -1
+// for elements that do not fit, and recipes -1 for the leaves. ctx is
+// checked between levels.
+func (g *RecipeGraph) layeredCosts(ctx context.Context, model CostModel, maxDepth int) ([][]float64, [][]int, error) {
+	g.indexOnce.Do(g.buildIndex)
+	index := g.index
+
+	costs := make([][]float64, maxDepth+1) // levels -> element -> price
+	chosen := make([][]int, maxDepth+1)    // levels -> element -> recipe
+	for levels := range costs {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		costs[levels] = make([]float64, len(g.elements))
+		chosen[levels] = make([]int, len(g.elements))
+		for id, name := range g.elements {
+			costs[levels][id] = -1
+			chosen[levels][id] = -1
+			if g.IsBase(name) {
+				costs[levels][id] = model.leafPrice(name)
+			}
+		}
+		if levels == 0 {
+			continue
+		}
+
+		below := costs[levels-1]
+		for recipe, result := range index.results {
+			if g.IsBase(g.elements[result]) {
+				continue
+			}
+			first, second := index.firsts[recipe], index.seconds[recipe]
+			if below[first] == -1 || below[second] == -1 {
+				continue
+			}
+			pair := Pair{g.elements[first], g.elements[second]}
+			cost := model.recipePrice(g.elements[result], pair) + below[first] + below[second]
+			if costs[levels][result] == -1 || cost < costs[levels][result] {
+				costs[levels][result] = cost
+				chosen[levels][result] = recipe
+			}
+		}
+	}
+
+	return costs, chosen, nil
+}
+
+// MinCombinationsWithin returns a function giving the fewest combinations a
+// tree of name needs when it must fit in levels levels, for any levels up to
+// maxDepth, or -1 when no tree fits. It returns the error of ctx when ctx is
+// done before the table is.
+func (g *RecipeGraph) MinCombinationsWithin(ctx context.Context, maxDepth int) (func(name string, levels int) int, error) {
+	costs, _, err := g.layeredCosts(ctx, CombinationsCost, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	return func(name string, levels int) int {
+		id, ok := g.index.id[name]
+		if !ok || levels < 0 || levels > maxDepth {
+			return -1
+		}
+		return int(costs[levels][id])
+	}, nil
+}
+
+// MinCombinations returns the fewest combinations any full recipe tree of
+// name needs, or -1 when it cannot be made. It is computed for every element
+// at once on first use.
+func (g *RecipeGraph) MinCombinations(name string) int {
+	g.combinationsOnce.Do(func() {
+		costs, chosen, _ := g.settleCosts(CombinationsCost, "")
+		g.combinations = make(map[string]int, len(g.elements))
+		for id, name := range g.elements {
+			if chosen[id] != -1 || g.IsBase(name) {
+				g.combinations[name] = int(costs[id])
+			}
+		}
+	})
+
+	if combinations, ok := g.combinations[name]; ok {
+		return combinations
+	}
+	return -1
+}
+
+// costWork is the work done by one run of settleCosts
+type costWork struct {
+	settled  int
+	maxQueue int
+}
+
+// settleCosts runs Knuth's algorithm with the prices of model, stopping once
+// target is settled when it is not empty. It returns the price of every
+// element and the index of the recipe it was settled with, -1 for base
+// elements and elements that were never settled.
+func (g *RecipeGraph) settleCosts(model CostModel, target string) ([]float64, []int, costWork) {
+	g.indexOnce.Do(g.buildIndex)
+	index := g.index
+
+	pending := make([]int, len(index.pending))
+	copy(pending, index.pending)
+	settled := make([]bool, len(g.elements))
+	costs := make([]float64, len(g.elements))
+	chosen := make([]int, len(g.elements))
+	for i := range chosen {
+		chosen[i] = -1
+	}
+
+	queue := &costQueue{}
+	for _, name := range g.base {
+		heap.Push(queue, costItem{model.leafPrice(name), name, -1})
+	}
+
+	work := costWork{}
+	for queue.Len() > 0 {
+		work.maxQueue = max(work.maxQueue, queue.Len())
+		item := heap.Pop(queue).(costItem)
+		id := index.id[item.name]
+		if settled[id] {
+			continue
+		}
+		settled[id] = true
+		work.settled++
+		costs[id] = item.cost
+		chosen[id] = item.recipe
+
+		if item.name == target {
+			break
+		}
+
+		for _, recipe := range index.users[id] {
+			pending[recipe]--
+			if pending[recipe] > 0 {
+				continue
+			}
+
+			result := index.results[recipe]
+			if settled[result] || g.IsBase(g.elements[result]) {
+				continue
+			}
+			first, second := index.firsts[recipe], index.seconds[recipe]
+			pair := Pair{g.elements[first], g.elements[second]}
+			cost := model.recipePrice(g.elements[result], pair) + costs[first] + costs[second]
+			heap.Push(queue, costItem{cost, g.elements[result], recipe})
+		}
+	}
+
+	return costs, chosen, work
+}
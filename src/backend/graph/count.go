@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"math/big"
+	"sort"
+)
+
+// CountTrees returns the number of distinct full recipe trees for target, in
+// which every leaf is a base element. Only recipes whose ingredients have a
+// lower tier than their result are counted, the same restriction the
+// searches apply by default, and trees that only differ in the order of the
+// ingredients of a recipe count once. The counts of every element are
+// computed on first use.
+//
+// The returned value is shared and must not be modified.
+func (g *RecipeGraph) CountTrees(target string) *big.Int {
+	g.countsOnce.Do(func() {
+		g.counts = g.countRestricted()
+	})
+
+	if g.Tier(target) == -1 {
+		return new(big.Int)
+	}
+	return g.counts[target]
+}
+
+// countRestricted counts trees over the tier-decreasing recipes, which form a
+// DAG, so settling elements in increasing tier order is enough
+func (g *RecipeGraph) countRestricted() map[string]*big.Int {
+	reachable := make([]string, 0, len(g.elements))
+	for _, name := range g.elements {
+		if g.Tier(name) != -1 {
+			reachable = append(reachable, name)
+		}
+	}
+	sort.SliceStable(reachable, func(i, j int) bool {
+		return g.Tier(reachable[i]) < g.Tier(reachable[j])
+	})
+
+	counts := make(map[string]*big.Int, len(reachable))
+	for _, name := range reachable {
+		if g.IsBase(name) {
+			counts[name] = big.NewInt(1)
+			continue
+		}
+
+		total := new(big.Int)
+		for _, pair := range UniqueRecipes(g.recipes[name]) {
+			if max(g.Tier(pair.First), g.Tier(pair.Second)) >= g.Tier(name) || g.Tier(pair.First) == -1 || g.Tier(pair.Second) == -1 {
+				continue
+			}
+
+			// A + A only needs one order of every two subtrees, n(n+1)/2
+			// combinations of n trees
+			if pair.First == pair.Second {
+				n := counts[pair.First]
+				same := new(big.Int).Add(n, big.NewInt(1))
+				same.Mul(same, n)
+				total.Add(total, same.Rsh(same, 1))
+				continue
+			}
+			total.Add(total, new(big.Int).Mul(counts[pair.First], counts[pair.Second]))
+		}
+		counts[name] = total
+	}
+
+	return counts
+}
@@ -0,0 +1,403 @@
+// Package graph holds the Little Alchemy recipe graph used by the solver.
+//
+// A RecipeGraph is built once, either from the scraped CSV files through
+// Load or in memory through a Builder, and is never modified afterwards, so a
+// single value can be shared freely between goroutines and several graphs can
+// live side by side in one process.
+package graph
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBaseElements are the elements a Little Alchemy 2 player starts with.
+var DefaultBaseElements = []string{"Air", "Water", "Earth", "Fire", "Time"}
+
+// Pair is one way of making an element: combining First with Second.
+type Pair struct {
+	First  string
+	Second string
+}
+
+// UniqueRecipes returns recipes without the repeats that only swap the
+// ingredients, since A + B and B + A make the same tree. The first order
+// listed is kept.
+func UniqueRecipes(recipes []Pair) []Pair {
+	seen := make(map[Pair]bool, len(recipes))
+	unique := make([]Pair, 0, len(recipes))
+	for _, pair := range recipes {
+		key := pair
+		if key.Second < key.First {
+			key.First, key.Second = key.Second, key.First
+		}
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, pair)
+		}
+	}
+	return unique
+}
+
+// RecipeGraph is an immutable AND-OR graph of elements and the recipes that
+// produce them, together with the precomputed tier of every element.
+type RecipeGraph struct {
+	recipes      map[string][]Pair   // element -> recipes that produce it
+	nextElements map[string][]string // ingredient -> results it is used in
+	imagesLink   map[string]string   // element (spaces as underscores) -> image link
+	base         []string            // elements available at tier 0, sorted
+	tiers        map[string]int      // element -> minimal tier, -1 when unreachable
+	best         map[string]Pair     // element -> recipe that achieves its tier
+	unreachable  []string            // elements with tier -1, sorted
+	elements     []string            // every known element, sorted
+
+	countsOnce sync.Once
+	counts     map[string]*big.Int // filled on first CountTrees
+
+	indexOnce sync.Once
+	index     *recipeIndex // filled on first settleTiers
+
+	combinationsOnce sync.Once
+	combinations     map[string]int // filled on first MinCombinations
+}
+
+// Recipes returns every recipe that produces name. The returned slice is
+// shared with the graph and must not be modified.
+func (g *RecipeGraph) Recipes(name string) []Pair {
+	return g.recipes[name]
+}
+
+// NextElements returns every result that name is an ingredient of, once per
+// recipe. The returned slice is shared with the graph and must not be
+// modified.
+func (g *RecipeGraph) NextElements(name string) []string {
+	return g.nextElements[name]
+}
+
+// Tier returns the minimal tier of name, or -1 when the element is unknown or
+// cannot be made from the base elements.
+func (g *RecipeGraph) Tier(name string) int {
+	if tier, ok := g.tiers[name]; ok {
+		return tier
+	}
+	return -1
+}
+
+// Image returns the image link of name as listed in images.csv, which keys
+// images either by element name or by file name ("Air_2.svg").
+func (g *RecipeGraph) Image(name string) (string, bool) {
+	key := strings.ReplaceAll(name, " ", "_")
+	if link, ok := g.imagesLink[key]; ok {
+		return link, true
+	}
+	link, ok := g.imagesLink[key+"_2.svg"]
+	return link, ok
+}
+
+// BestRecipe returns the recipe that achieves the tier of name. Base elements
+// and unreachable elements have none.
+func (g *RecipeGraph) BestRecipe(name string) (Pair, bool) {
+	pair, ok := g.best[name]
+	return pair, ok
+}
+
+// Unreachable returns every element that cannot be made from the base
+// elements, in sorted order. The returned slice is shared with the graph and
+// must not be modified.
+func (g *RecipeGraph) Unreachable() []string {
+	return g.unreachable
+}
+
+// BaseElements returns the elements available from the start, in sorted
+// order. The returned slice is shared with the graph and must not be
+// modified.
+func (g *RecipeGraph) BaseElements() []string {
+	return g.base
+}
+
+// IsBase reports whether name is one of the base elements.
+func (g *RecipeGraph) IsBase(name string) bool {
+	return g.Tier(name) == 0
+}
+
+// WithBaseElements returns a graph with the same recipes and images as g but
+// with tiers computed from the given base elements instead. The two graphs
+// share their recipe data, so deriving one is cheap.
+func (g *RecipeGraph) WithBaseElements(names []string) (*RecipeGraph, error) {
+	for _, name := range names {
+		if !g.Has(name) {
+			return nil, fmt.Errorf("unknown base element %q", name)
+		}
+	}
+
+	derived := &RecipeGraph{
+		recipes:      g.recipes,
+		nextElements: g.nextElements,
+		imagesLink:   g.imagesLink,
+		elements:     g.elements,
+		base:         uniqueSorted(names),
+	}
+	derived.findAllTiers()
+
+	return derived, nil
+}
+
+// Without returns a graph in which the given elements cannot be used at all:
+// every recipe that makes one of them or needs one as an ingredient is gone,
+// and they are no longer base elements. Tiers are recomputed accordingly, so
+// the excluded elements and everything that depended on them get tier -1.
+func (g *RecipeGraph) Without(names []string) (*RecipeGraph, error) {
+	excluded := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !g.Has(name) {
+			return nil, fmt.Errorf("unknown excluded element %q", name)
+		}
+		excluded[name] = true
+	}
+
+	derived := &RecipeGraph{
+		recipes:      make(map[string][]Pair, len(g.recipes)),
+		nextElements: make(map[string][]string, len(g.nextElements)),
+		imagesLink:   g.imagesLink,
+		elements:     g.elements,
+		base:         make([]string, 0, len(g.base)),
+	}
+
+	for _, result := range g.elements {
+		if excluded[result] {
+			continue
+		}
+		for _, pair := range g.recipes[result] {
+			if excluded[pair.First] || excluded[pair.Second] {
+				continue
+			}
+			derived.recipes[result] = append(derived.recipes[result], pair)
+			derived.nextElements[pair.First] = append(derived.nextElements[pair.First], result)
+			derived.nextElements[pair.Second] = append(derived.nextElements[pair.Second], result)
+		}
+	}
+
+	for _, name := range g.base {
+		if !excluded[name] {
+			derived.base = append(derived.base, name)
+		}
+	}
+	derived.findAllTiers()
+
+	return derived, nil
+}
+
+// Has reports whether name appears anywhere in the graph, either as a result
+// or as an ingredient.
+func (g *RecipeGraph) Has(name string) bool {
+	_, ok := g.tiers[name]
+	return ok
+}
+
+// Elements returns every element of the graph in sorted order. The returned
+// slice is shared with the graph and must not be modified.
+func (g *RecipeGraph) Elements() []string {
+	return g.elements
+}
+
+// Builder accumulates recipes and images for a new RecipeGraph.
+type Builder struct {
+	recipes      map[string][]Pair
+	nextElements map[string][]string
+	imagesLink   map[string]string
+	known        map[string]bool
+	base         []string
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{
+		recipes:      make(map[string][]Pair),
+		nextElements: make(map[string][]string),
+		imagesLink:   make(map[string]string),
+		known:        make(map[string]bool),
+	}
+}
+
+// AddRecipe records that result can be made by combining first with second.
+func (b *Builder) AddRecipe(result, first, second string) {
+	b.recipes[result] = append(b.recipes[result], Pair{first, second})
+	b.nextElements[first] = append(b.nextElements[first], result)
+	b.nextElements[second] = append(b.nextElements[second], result)
+
+	b.known[result] = true
+	b.known[first] = true
+	b.known[second] = true
+}
+
+// SetBaseElements replaces DefaultBaseElements as the starting set. Names
+// that never appear in a recipe are ignored.
+func (b *Builder) SetBaseElements(names []string) {
+	b.base = names
+}
+
+// SetImage records the image link of an element.
+func (b *Builder) SetImage(name, link string) {
+	b.imagesLink[strings.ReplaceAll(name, " ", "_")] = link
+}
+
+// Build computes the tier of every element and returns the finished graph.
+// The Builder must not be used afterwards.
+func (b *Builder) Build() *RecipeGraph {
+	g := &RecipeGraph{
+		recipes:      b.recipes,
+		nextElements: b.nextElements,
+		imagesLink:   b.imagesLink,
+	}
+
+	g.elements = make([]string, 0, len(b.known))
+	for name := range b.known {
+		g.elements = append(g.elements, name)
+	}
+	sort.Strings(g.elements)
+
+	base := b.base
+	if base == nil {
+		base = DefaultBaseElements
+	}
+	g.base = make([]string, 0, len(base))
+	for _, name := range base {
+		if b.known[name] {
+			g.base = append(g.base, name)
+		}
+	}
+	g.base = uniqueSorted(g.base)
+
+	g.findAllTiers()
+
+	return g
+}
+
+// uniqueSorted returns a sorted copy of names without duplicates
+func uniqueSorted(names []string) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return slices.Compact(sorted)
+}
+
+// Load reads recipesPath and imagesPath and builds a RecipeGraph from them.
+// A header row at the top of either file is skipped. base replaces
+// DefaultBaseElements when it is not nil.
+func Load(recipesPath, imagesPath string, base []string) (*RecipeGraph, error) {
+	b := NewBuilder()
+	b.SetBaseElements(base)
+
+	recipeRows, err := readRows(recipesPath)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range recipeRows {
+		if i == 0 && isHeader(row.fields, recipesHeader) {
+			continue
+		}
+		if len(row.fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected 3 fields, got %d", recipesPath, row.line, len(row.fields))
+		}
+		b.AddRecipe(row.fields[0], row.fields[1], row.fields[2])
+	}
+
+	imageRows, err := readRows(imagesPath)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range imageRows {
+		if i == 0 && isHeader(row.fields, imagesHeader) {
+			continue
+		}
+		if len(row.fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected 2 fields, got %d", imagesPath, row.line, len(row.fields))
+		}
+		b.SetImage(row.fields[0], row.fields[1])
+	}
+
+	return b.Build(), nil
+}
+
+// Header rows written by the scraper, and of the hand-written alias table
+var (
+	recipesHeader = []string{"Element", "Combination1", "Combination2"}
+	imagesHeader  = []string{"Element", "Link"}
+	aliasesHeader = []string{"Alias", "Element"}
+	baseHeader    = []string{"Element"}
+)
+
+// isHeader reports whether fields is the given header row, ignoring case
+func isHeader(fields, header []string) bool {
+	if len(fields) != len(header) {
+		return false
+	}
+	for i := range fields {
+		if !strings.EqualFold(strings.TrimSpace(fields[i]), header[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadBaseElements reads the base elements of a dataset from a CSV file with
+// one element per row. A missing file yields nil, meaning the defaults.
+func LoadBaseElements(path string) ([]string, error) {
+	rows, err := readRows(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	base := make([]string, 0, len(rows))
+	for i, row := range rows {
+		if i == 0 && isHeader(row.fields, baseHeader) {
+			continue
+		}
+		if len(row.fields) != 1 {
+			return nil, fmt.Errorf("%s:%d: expected 1 field, got %d", path, row.line, len(row.fields))
+		}
+		base = append(base, strings.TrimSpace(row.fields[0]))
+	}
+
+	return base, nil
+}
+
+// csvRow is one CSV record together with the line it starts on
+type csvRow struct {
+	line   int
+	fields []string
+}
+
+func readRows(path string) ([]csvRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	rows := make([]csvRow, 0)
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, csvRow{line, fields})
+	}
+	return rows, nil
+}
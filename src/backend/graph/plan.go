@@ -0,0 +1,333 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Step is one combination of a crafting plan.
+type Step struct {
+	Result string `json:"result"`
+	First  string `json:"first"`
+	Second string `json:"second"`
+}
+
+// Plan is a way of crafting a target in which every element is made once and
+// reused wherever it is needed, so the recipes form a DAG rather than a tree.
+type Plan struct {
+	Target   string `json:"target"`
+	Steps    []Step `json:"steps"`    // In crafting order: ingredients come before results
+	Optimal  bool   `json:"optimal"`  // Whether Steps is proven to be the fewest possible
+	Expanded int    `json:"expanded"` // Search nodes spent looking for the plan
+}
+
+// planner is the state of one branch and bound search for a minimal plan
+type planner struct {
+	g        *RecipeGraph
+	target   string
+	budget   int
+	maxDepth int // deepest level below the target a plan may use, 0 for any
+	expanded int
+
+	usable map[string][]Pair // element -> recipes that could appear in a plan
+	chosen map[string]Pair   // element -> recipe picked for it
+	open   []string          // elements in the plan still waiting for a recipe
+	inPlan map[string]bool   // chosen or open
+
+	best map[string]Pair
+}
+
+// Plan returns a crafting plan for target with as few distinct combinations
+// as the search could find. Minimizing a shared plan is NP-hard in general,
+// so the search gives up after budget nodes and then returns the best plan so
+// far with Optimal set to false. A budget of 0 or less means no limit.
+//
+// With maxDepth above 0 only plans in which no chain of combinations runs
+// more than maxDepth levels below the target count. The quick plans only
+// follow recipes that lower the tier, so they always fit when the target's
+// tier does.
+func (g *RecipeGraph) Plan(target string, budget int, maxDepth int) (*Plan, error) {
+	if g.Tier(target) == -1 {
+		return nil, fmt.Errorf("%s cannot be made from the base elements", target)
+	}
+	if maxDepth > 0 && g.Tier(target) > maxDepth {
+		return nil, fmt.Errorf("no recipe for %s within depth %d", target, maxDepth)
+	}
+
+	p := &planner{
+		g:        g,
+		target:   target,
+		budget:   budget,
+		maxDepth: maxDepth,
+		usable:   make(map[string][]Pair),
+		chosen:   make(map[string]Pair),
+		inPlan:   make(map[string]bool),
+	}
+
+	// Start from the better of two quick plans so the exact search can
+	// prune from its very first branch
+	p.best = p.bestRecipePlan(target)
+	if greedy := p.greedyPlan(target); len(greedy) < len(p.best) {
+		p.best = greedy
+	}
+
+	optimal := true
+	if !g.IsBase(target) {
+		p.inPlan[target] = true
+		p.open = append(p.open, target)
+		optimal = p.search()
+	}
+
+	return &Plan{
+		Target:   target,
+		Steps:    orderSteps(target, p.best),
+		Optimal:  optimal,
+		Expanded: p.expanded,
+	}, nil
+}
+
+// search extends the partial plan depth first. It returns false when the
+// budget ran out before the subtree was fully explored.
+func (p *planner) search() bool {
+	p.expanded++
+	if p.budget > 0 && p.expanded > p.budget {
+		return false
+	}
+
+	// Every element in the plan costs one step, so a partial plan that is
+	// already as large as the best complete one cannot improve on it
+	if len(p.inPlan) >= len(p.best) {
+		return true
+	}
+
+	if len(p.open) == 0 {
+		if p.maxDepth > 0 && p.height(p.target, make(map[string]int)) > p.maxDepth {
+			return true
+		}
+		p.best = make(map[string]Pair, len(p.chosen))
+		for name, pair := range p.chosen {
+			p.best[name] = pair
+		}
+		return true
+	}
+
+	// Branch on the open element with the fewest usable recipes first
+	pick := 0
+	for i := 1; i < len(p.open); i++ {
+		if len(p.usableRecipes(p.open[i])) < len(p.usableRecipes(p.open[pick])) {
+			pick = i
+		}
+	}
+
+	name := p.open[pick]
+	candidates := p.candidates(name)
+	p.open = append(p.open[:pick:pick], p.open[pick+1:]...)
+	defer func() {
+		p.open = append(p.open[:pick], append([]string{name}, p.open[pick:]...)...)
+	}()
+
+	for _, pair := range candidates {
+		added, n := p.newIngredients(pair)
+		if len(p.inPlan)+n >= len(p.best) {
+			continue
+		}
+		if p.dependsOn(pair.First, name) || p.dependsOn(pair.Second, name) {
+			continue // would make the plan cyclic
+		}
+
+		p.chosen[name] = pair
+		for _, ingredient := range added[:n] {
+			p.inPlan[ingredient] = true
+			p.open = append(p.open, ingredient)
+		}
+
+		complete := p.search()
+
+		p.open = p.open[:len(p.open)-n]
+		for _, ingredient := range added[:n] {
+			delete(p.inPlan, ingredient)
+		}
+		delete(p.chosen, name)
+
+		if !complete {
+			return false
+		}
+	}
+
+	return true
+}
+
+// usableRecipes returns the recipes of name that could appear in a plan at
+// all: those with reachable ingredients that do not use name itself
+func (p *planner) usableRecipes(name string) []Pair {
+	if pairs, ok := p.usable[name]; ok {
+		return pairs
+	}
+
+	pairs := make([]Pair, 0)
+	for _, pair := range p.g.Recipes(name) {
+		if pair.First == name || pair.Second == name {
+			continue
+		}
+		if p.g.Tier(pair.First) == -1 || p.g.Tier(pair.Second) == -1 {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+
+	p.usable[name] = pairs
+	return pairs
+}
+
+// candidates returns the usable recipes of name, those adding the fewest new
+// elements to the plan first
+func (p *planner) candidates(name string) []Pair {
+	usable := p.usableRecipes(name)
+
+	type candidate struct {
+		pair  Pair
+		added int
+		tier  int
+	}
+	ranked := make([]candidate, len(usable))
+	for i, pair := range usable {
+		_, added := p.newIngredients(pair)
+		ranked[i] = candidate{pair, added, pairTier(p.g, pair)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].added != ranked[j].added {
+			return ranked[i].added < ranked[j].added
+		}
+		return ranked[i].tier < ranked[j].tier
+	})
+
+	pairs := make([]Pair, len(ranked))
+	for i, c := range ranked {
+		pairs[i] = c.pair
+	}
+	return pairs
+}
+
+// newIngredients returns the ingredients of pair that would join the plan,
+// and how many there are
+func (p *planner) newIngredients(pair Pair) ([2]string, int) {
+	var added [2]string
+	n := 0
+	if !p.g.IsBase(pair.First) && !p.inPlan[pair.First] {
+		added[n] = pair.First
+		n++
+	}
+	if pair.Second != pair.First && !p.g.IsBase(pair.Second) && !p.inPlan[pair.Second] {
+		added[n] = pair.Second
+		n++
+	}
+	return added, n
+}
+
+// dependsOn reports whether making from needs target, following the recipes
+// chosen so far
+func (p *planner) dependsOn(from, target string) bool {
+	if from == target {
+		return true
+	}
+	pair, ok := p.chosen[from]
+	if !ok {
+		return false
+	}
+	return p.dependsOn(pair.First, target) || p.dependsOn(pair.Second, target)
+}
+
+// height returns the longest chain of chosen combinations below name,
+// remembering the heights found in heights
+func (p *planner) height(name string, heights map[string]int) int {
+	pair, ok := p.chosen[name]
+	if !ok {
+		return 0
+	}
+	if height, ok := heights[name]; ok {
+		return height
+	}
+	height := 1 + max(p.height(pair.First, heights), p.height(pair.Second, heights))
+	heights[name] = height
+	return height
+}
+
+// bestRecipePlan shares the tier-minimal recipes of every element
+func (p *planner) bestRecipePlan(target string) map[string]Pair {
+	plan := make(map[string]Pair)
+	var visit func(name string)
+	visit = func(name string) {
+		if _, ok := plan[name]; ok || p.g.IsBase(name) {
+			return
+		}
+		pair, _ := p.g.BestRecipe(name)
+		plan[name] = pair
+		visit(pair.First)
+		visit(pair.Second)
+	}
+	visit(target)
+	return plan
+}
+
+// greedyPlan picks, element by element, the recipe that reuses the most of
+// what the plan already contains, only following recipes that lower the tier
+// so the result can never be cyclic
+func (p *planner) greedyPlan(target string) map[string]Pair {
+	plan := make(map[string]Pair)
+	var visit func(name string)
+	visit = func(name string) {
+		if _, ok := plan[name]; ok || p.g.IsBase(name) {
+			return
+		}
+
+		var best Pair
+		bestAdded := 3
+		for _, pair := range p.g.Recipes(name) {
+			if pairTier(p.g, pair) >= p.g.Tier(name) || p.g.Tier(pair.First) == -1 || p.g.Tier(pair.Second) == -1 {
+				continue
+			}
+			added := 0
+			for _, ingredient := range []string{pair.First, pair.Second} {
+				if _, ok := plan[ingredient]; !ok && !p.g.IsBase(ingredient) {
+					added++
+				}
+			}
+			if added < bestAdded {
+				best, bestAdded = pair, added
+			}
+		}
+
+		plan[name] = best
+		visit(best.First)
+		visit(best.Second)
+	}
+	visit(target)
+	return plan
+}
+
+// pairTier is the tier a recipe produces its result at, minus one
+func pairTier(g *RecipeGraph, pair Pair) int {
+	return max(g.Tier(pair.First), g.Tier(pair.Second))
+}
+
+// orderSteps lists the recipes of a plan so every ingredient is made before
+// it is used
+func orderSteps(target string, plan map[string]Pair) []Step {
+	steps := make([]Step, 0, len(plan))
+	done := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		pair, ok := plan[name]
+		if !ok || done[name] {
+			return
+		}
+		done[name] = true
+		visit(pair.First)
+		visit(pair.Second)
+		steps = append(steps, Step{name, pair.First, pair.Second})
+	}
+	visit(target)
+
+	return steps
+}
@@ -0,0 +1,166 @@
+package graph
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Suggestion is a known element that is close to a name that did not match.
+type Suggestion struct {
+	Name     string `json:"name"`
+	Distance int    `json:"distance"`
+}
+
+// Resolver maps user-typed names onto the elements of a RecipeGraph. Matching
+// ignores case, treats underscores as spaces, collapses runs of whitespace
+// and honours an alias table.
+type Resolver struct {
+	names map[string]string // normalized name or alias -> element
+}
+
+// maxSuggestions is the number of suggestions returned on a miss
+const maxSuggestions = 5
+
+// NewResolver returns a Resolver over the elements of g. aliases maps
+// alternative names to element names; aliases whose target is not an element
+// of g are ignored.
+func NewResolver(g *RecipeGraph, aliases map[string]string) *Resolver {
+	r := &Resolver{names: make(map[string]string, len(g.Elements())+len(aliases))}
+
+	for _, name := range g.Elements() {
+		r.names[normalizeName(name)] = name
+	}
+
+	resolved := make(map[string]string, len(aliases))
+	for alias, target := range aliases {
+		element, ok := r.names[normalizeName(target)]
+		if !ok {
+			continue
+		}
+		key := normalizeName(alias)
+		if _, taken := r.names[key]; taken {
+			continue // a real element always wins over an alias
+		}
+		resolved[key] = element
+	}
+	for key, element := range resolved {
+		r.names[key] = element
+	}
+
+	return r
+}
+
+// Resolve returns the element that query refers to. On a miss it returns
+// false together with the closest known names, best first.
+func (r *Resolver) Resolve(query string) (string, []Suggestion, bool) {
+	key := normalizeName(query)
+	if element, ok := r.names[key]; ok {
+		return element, nil, true
+	}
+	return "", r.suggest(key), false
+}
+
+// suggest ranks known names by edit distance to key
+func (r *Resolver) suggest(key string) []Suggestion {
+	if key == "" {
+		return []Suggestion{}
+	}
+
+	// Allow roughly one typo every three characters, and at least two
+	limit := max(2, utf8.RuneCountInString(key)/3)
+
+	best := make(map[string]int) // element -> smallest distance over its names
+	for candidate, element := range r.names {
+		distance := levenshtein(key, candidate)
+
+		// A query that is a prefix of a long name ("philosopher") is a
+		// good match even though many characters are missing
+		if strings.HasPrefix(candidate, key) && utf8.RuneCountInString(key) >= 3 {
+			distance = min(distance, 1)
+		}
+
+		if distance > limit {
+			continue
+		}
+		if old, ok := best[element]; !ok || distance < old {
+			best[element] = distance
+		}
+	}
+
+	suggestions := make([]Suggestion, 0, len(best))
+	for element, distance := range best {
+		suggestions = append(suggestions, Suggestion{element, distance})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Distance != suggestions[j].Distance {
+			return suggestions[i].Distance < suggestions[j].Distance
+		}
+		return suggestions[i].Name < suggestions[j].Name
+	})
+
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
+
+// normalizeName lowercases name, turns underscores into spaces and collapses
+// whitespace so "  steam_ENGINE " and "Steam engine" compare equal
+func normalizeName(name string) string {
+	name = strings.ReplaceAll(name, "_", " ")
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// levenshtein returns the edit distance between a and b, counted in runes
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// LoadAliases reads an alias table from a CSV file of "alias,element" rows.
+// A missing file is not an error and yields an empty table.
+func LoadAliases(path string) (map[string]string, error) {
+	aliases := make(map[string]string)
+
+	rows, err := readRows(path)
+	if os.IsNotExist(err) {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range rows {
+		if i == 0 && isHeader(row.fields, aliasesHeader) {
+			continue
+		}
+		if len(row.fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected 2 fields, got %d", path, row.line, len(row.fields))
+		}
+		aliases[strings.TrimSpace(row.fields[0])] = strings.TrimSpace(row.fields[1])
+	}
+
+	return aliases, nil
+}
@@ -0,0 +1,181 @@
+package graph
+
+import "container/heap"
+
+// tierItem is a tentative tier for an element waiting in the tierQueue.
+type tierItem struct {
+	tier int
+	name string
+}
+
+// tierQueue is a min-heap of tierItems ordered by tier, then by name so the
+// order in which equal tiers are settled does not depend on map iteration.
+type tierQueue []tierItem
+
+func (q tierQueue) Len() int { return len(q) }
+func (q tierQueue) Less(i, j int) bool {
+	if q[i].tier != q[j].tier {
+		return q[i].tier < q[j].tier
+	}
+	return q[i].name < q[j].name
+}
+func (q tierQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *tierQueue) Push(x any)   { *q = append(*q, x.(tierItem)) }
+func (q *tierQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+/*	Knuth's generalization of Dijkstra's algorithm to AND-OR graphs
+*	Reference : D. E. Knuth, "A generalization of Dijkstra's algorithm",
+*	Information Processing Letters 6(1), 1977
+*
+*	The tier of a recipe is max(tier(First), tier(Second)) + 1, which is a
+*	superior function, so elements can be settled in increasing tier order
+*	exactly like Dijkstra settles vertices. A recipe becomes usable once all of
+*	its distinct ingredients are settled, and every element is settled exactly
+*	once with its minimal tier, however deep it is.
+ */
+func (g *RecipeGraph) findAllTiers() {
+	g.tiers = make(map[string]int, len(g.elements))
+	for _, name := range g.elements {
+		g.tiers[name] = -1
+	}
+	for name, tier := range g.settleTiers(nil) {
+		g.tiers[name] = tier
+	}
+
+	// Record the first recipe, in file order, that achieves each tier
+	g.best = make(map[string]Pair)
+	g.unreachable = make([]string, 0)
+	for _, name := range g.elements { // already sorted
+		tier := g.tiers[name]
+		if tier == -1 {
+			g.unreachable = append(g.unreachable, name)
+			continue
+		}
+		if tier == 0 {
+			continue
+		}
+		for _, pair := range g.recipes[name] {
+			if max(g.tiers[pair.First], g.tiers[pair.Second])+1 == tier && g.tiers[pair.First] != -1 && g.tiers[pair.Second] != -1 {
+				g.best[name] = pair
+				break
+			}
+		}
+	}
+}
+
+// TiersAvoiding returns the minimal tier of every element that can still be
+// made when the elements in avoid may not be used at all, neither as a result
+// nor as an ingredient. Elements missing from the result cannot be made.
+func (g *RecipeGraph) TiersAvoiding(avoid map[string]bool) map[string]int {
+	return g.settleTiers(avoid)
+}
+
+// recipeIndex numbers the elements and recipes of a graph so repeated runs of
+// Knuth's algorithm only need to copy a few slices
+type recipeIndex struct {
+	id      map[string]int // element -> position in g.elements
+	results []int          // recipe -> element it produces
+	firsts  []int          // recipe -> first ingredient
+	seconds []int          // recipe -> second ingredient
+	users   [][]int        // element -> recipes using it as an ingredient
+	pending []int          // recipe -> number of distinct ingredients
+}
+
+func (g *RecipeGraph) buildIndex() {
+	index := &recipeIndex{
+		id:    make(map[string]int, len(g.elements)),
+		users: make([][]int, len(g.elements)),
+	}
+	for i, name := range g.elements {
+		index.id[name] = i
+	}
+
+	for result, name := range g.elements {
+		for _, pair := range g.recipes[name] {
+			first, okFirst := index.id[pair.First]
+			second, okSecond := index.id[pair.Second]
+			if !okFirst || !okSecond {
+				continue
+			}
+
+			recipe := len(index.results)
+			index.results = append(index.results, result)
+			index.firsts = append(index.firsts, first)
+			index.seconds = append(index.seconds, second)
+
+			index.users[first] = append(index.users[first], recipe)
+			if second != first {
+				index.users[second] = append(index.users[second], recipe)
+				index.pending = append(index.pending, 2)
+			} else {
+				index.pending = append(index.pending, 1)
+			}
+		}
+	}
+
+	g.index = index
+}
+
+// settleTiers runs Knuth's algorithm from the base elements, skipping every
+// element in avoid, and returns the tiers of the elements it settled
+func (g *RecipeGraph) settleTiers(avoid map[string]bool) map[string]int {
+	g.indexOnce.Do(g.buildIndex)
+	index := g.index
+
+	avoided := make([]bool, len(g.elements))
+	for name := range avoid {
+		if id, ok := index.id[name]; ok {
+			avoided[id] = true
+		}
+	}
+
+	pending := make([]int, len(index.pending))
+	copy(pending, index.pending)
+	tiers := make([]int, len(g.elements))
+	for i := range tiers {
+		tiers[i] = -1
+	}
+
+	queue := &tierQueue{}
+	for _, name := range g.base {
+		if !avoid[name] {
+			heap.Push(queue, tierItem{0, name})
+		}
+	}
+
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(tierItem)
+		id := index.id[item.name]
+		if tiers[id] != -1 {
+			continue
+		}
+		tiers[id] = item.tier
+
+		for _, recipe := range index.users[id] {
+			pending[recipe]--
+			if pending[recipe] > 0 {
+				continue
+			}
+
+			result := index.results[recipe]
+			if tiers[result] != -1 || avoided[result] {
+				continue
+			}
+			tier := max(tiers[index.firsts[recipe]], tiers[index.seconds[recipe]]) + 1
+			heap.Push(queue, tierItem{tier, g.elements[result]})
+		}
+	}
+
+	settled := make(map[string]int)
+	for id, tier := range tiers {
+		if tier != -1 {
+			settled[g.elements[id]] = tier
+		}
+	}
+	return settled
+}
@@ -0,0 +1,210 @@
+package graph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Severity tells whether an Issue makes a dataset unusable.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue kinds reported by Validate
+const (
+	IssueUnreadable          = "unreadable"
+	IssueMalformedRow        = "malformed-row"
+	IssueHeaderLeakage       = "header-leakage"
+	IssueEmptyDataset        = "empty-dataset"
+	IssueMissingBase         = "missing-base-element"
+	IssueDuplicateRecipe     = "duplicate-recipe"
+	IssueMirroredRecipe      = "mirrored-recipe"
+	IssueSelfReference       = "self-reference"
+	IssueUndefinedIngredient = "undefined-ingredient"
+	IssueMissingImageLink    = "missing-image-link"
+	IssueMissingImageFile    = "missing-image-file"
+	IssueUnreachable         = "unreachable"
+)
+
+// Issue is a single problem found in the data files.
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Kind     string   `json:"kind"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Element  string   `json:"element,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// Report is the outcome of validating a dataset.
+type Report struct {
+	Elements int     `json:"elements"`
+	Recipes  int     `json:"recipes"`
+	Issues   []Issue `json:"issues"`
+}
+
+// Errors returns the number of error issues.
+func (r *Report) Errors() int {
+	return r.count(SeverityError)
+}
+
+// Warnings returns the number of warning issues.
+func (r *Report) Warnings() int {
+	return r.count(SeverityWarning)
+}
+
+func (r *Report) count(severity Severity) int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) add(severity Severity, kind, file string, line int, element, format string, args ...any) {
+	r.Issues = append(r.Issues, Issue{
+		Severity: severity,
+		Kind:     kind,
+		File:     file,
+		Line:     line,
+		Element:  element,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks recipesPath and imagesPath for problems that Load would
+// either reject or silently accept. When imagesDir is not empty every element
+// is also expected to have an <Element>_2.svg file there. base replaces
+// DefaultBaseElements when it is not nil.
+//
+// Errors are problems that make the data unusable, such as unparsable rows
+// or a header row in the middle of the data. Warnings flag data that loads
+// but is suspicious, such as duplicate recipes or unreachable elements.
+func Validate(recipesPath, imagesPath, imagesDir string, base []string) *Report {
+	report := &Report{Issues: make([]Issue, 0)}
+	b := NewBuilder()
+	b.SetBaseElements(base)
+	if base == nil {
+		base = DefaultBaseElements
+	}
+
+	recipeRows, err := readRows(recipesPath)
+	if err != nil {
+		report.add(SeverityError, IssueUnreadable, recipesPath, 0, "", "%v", err)
+	}
+
+	type recipeKey struct{ result, first, second string }
+	seen := make(map[recipeKey]int) // recipe -> first line it appeared on
+	results := make(map[string]bool)
+	ingredients := make(map[string]int) // ingredient -> first line it appeared on
+
+	for i, row := range recipeRows {
+		if len(row.fields) > 0 && strings.EqualFold(strings.TrimSpace(row.fields[0]), recipesHeader[0]) {
+			if i == 0 && isHeader(row.fields, recipesHeader) {
+				continue
+			}
+			report.add(SeverityError, IssueHeaderLeakage, recipesPath, row.line, "", "header row %q would be loaded as an element", strings.Join(row.fields, ","))
+			continue
+		}
+
+		if len(row.fields) != 3 {
+			report.add(SeverityError, IssueMalformedRow, recipesPath, row.line, "", "expected 3 fields, got %d", len(row.fields))
+			continue
+		}
+
+		result, first, second := row.fields[0], row.fields[1], row.fields[2]
+		if result == "" || first == "" || second == "" {
+			report.add(SeverityError, IssueMalformedRow, recipesPath, row.line, result, "empty element name")
+			continue
+		}
+
+		if line, ok := seen[recipeKey{result, first, second}]; ok {
+			report.add(SeverityWarning, IssueDuplicateRecipe, recipesPath, row.line, result, "%s + %s duplicates line %d", first, second, line)
+			continue
+		}
+		if line, ok := seen[recipeKey{result, second, first}]; ok {
+			report.add(SeverityWarning, IssueMirroredRecipe, recipesPath, row.line, result, "%s + %s mirrors %s + %s on line %d", first, second, second, first, line)
+			continue
+		}
+		seen[recipeKey{result, first, second}] = row.line
+
+		if first == result || second == result {
+			report.add(SeverityWarning, IssueSelfReference, recipesPath, row.line, result, "%s is an ingredient of its own recipe %s + %s", result, first, second)
+		}
+
+		results[result] = true
+		for _, ingredient := range []string{first, second} {
+			if _, ok := ingredients[ingredient]; !ok {
+				ingredients[ingredient] = row.line
+			}
+		}
+
+		b.AddRecipe(result, first, second)
+		report.Recipes++
+	}
+
+	imageRows, err := readRows(imagesPath)
+	if err != nil {
+		report.add(SeverityError, IssueUnreadable, imagesPath, 0, "", "%v", err)
+	}
+	for i, row := range imageRows {
+		if len(row.fields) > 0 && strings.EqualFold(strings.TrimSpace(row.fields[0]), imagesHeader[0]) {
+			if i == 0 && isHeader(row.fields, imagesHeader) {
+				continue
+			}
+			report.add(SeverityError, IssueHeaderLeakage, imagesPath, row.line, "", "header row %q would be loaded as an image", strings.Join(row.fields, ","))
+			continue
+		}
+
+		if len(row.fields) != 2 {
+			report.add(SeverityError, IssueMalformedRow, imagesPath, row.line, "", "expected 2 fields, got %d", len(row.fields))
+			continue
+		}
+		b.SetImage(row.fields[0], row.fields[1])
+	}
+
+	g := b.Build()
+	report.Elements = len(g.Elements())
+
+	if report.Elements == 0 {
+		report.add(SeverityError, IssueEmptyDataset, recipesPath, 0, "", "dataset has no recipes")
+		return report
+	}
+
+	for _, name := range base {
+		if !g.Has(name) {
+			report.add(SeverityError, IssueMissingBase, recipesPath, 0, name, "base element %s is never used", name)
+		}
+	}
+
+	for _, name := range g.Elements() {
+		if line, ok := ingredients[name]; ok && !results[name] && !g.IsBase(name) {
+			report.add(SeverityWarning, IssueUndefinedIngredient, recipesPath, line, name, "%s is used as an ingredient but no recipe makes it", name)
+		}
+	}
+
+	for _, name := range g.Elements() {
+		if _, ok := g.Image(name); !ok {
+			report.add(SeverityWarning, IssueMissingImageLink, imagesPath, 0, name, "%s has no entry in %s", name, filepath.Base(imagesPath))
+		}
+		if imagesDir != "" {
+			file := filepath.Join(imagesDir, strings.ReplaceAll(name, " ", "_")+"_2.svg")
+			if _, err := os.Stat(file); err != nil {
+				report.add(SeverityWarning, IssueMissingImageFile, file, 0, name, "%s has no image file", name)
+			}
+		}
+	}
+
+	for _, name := range g.Unreachable() {
+		report.add(SeverityWarning, IssueUnreachable, recipesPath, 0, name, "%s cannot be made from the base elements", name)
+	}
+
+	return report
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// depthKey is an element with the levels left below it
+type depthKey struct {
+	name   string
+	levels int
+}
+
+/*	Iterative deepening DFS
+*
+*	Each iteration runs a depth limited DFS that tries to complete the whole
+*	tree within limit levels: a node succeeds when one of its recipes has two
+*	succeeding ingredients, tried in file order. The limit grows by one until a
+*	tree is found, so the first tree found is as low as possible while only the
+*	current path is kept in memory. The limit alone stops cycles, and whether
+*	an element fits in some number of levels never depends on where it is, so
+*	failures are remembered across iterations.
+*
+*	The context is checked at every node, and once it is done the search
+*	unwinds and returns the context's error.
+ */
+func iddfs(ctx context.Context, g *graph.RecipeGraph, target string, maxDepth int, stats *SearchStats) (*tree, int, searchCounts, error) {
+	failed := make(map[depthKey]bool)
+	counts := searchCounts{} // the frontier of a DFS is the path it is on
+
+	var search func(node *tree, levels int) bool
+	search = func(node *tree, levels int) bool {
+		if g.IsBase(node.now) {
+			return true
+		}
+		if levels == 0 || failed[depthKey{node.now, levels}] || ctx.Err() != nil {
+			return false
+		}
+		counts.expanded++
+		counts.maxFrontier = max(counts.maxFrontier, node.depth+1)
+		expandStep := stats.emitExpand(node.now, parentName(node), node.depth, node.depth)
+
+		for _, pair := range graph.UniqueRecipes(g.Recipes(node.now)) {
+			left := &tree{now: pair.First, depth: node.depth + 1, parent: node}
+			right := &tree{now: pair.Second, depth: node.depth + 1, parent: node}
+			recipeStep := stats.emitRecipe(node.now, parentName(node), node.depth, pair)
+			if search(left, levels-1) && search(right, levels-1) {
+				node.children = []*tree{left, right}
+				node.childCount = 2
+				node.steps = []int{expandStep, recipeStep}
+				return true
+			}
+		}
+
+		// A search cut short proves nothing
+		if ctx.Err() != nil {
+			return false
+		}
+		failed[depthKey{node.now, levels}] = true
+		stats.emitBacktrack(node.now, parentName(node), node.depth)
+		return false
+	}
+
+	// A tree never needs more levels than there are elements, which bounds
+	// the search when there is no limit
+	limit := maxDepth
+	if limit == 0 {
+		limit = len(g.Elements())
+	}
+
+	for depth := 0; depth <= limit; depth++ {
+		root := &tree{now: target}
+		if search(root, depth) {
+			collapseRepeats(root)
+			return root, depth, counts, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, depth, counts, err
+		}
+	}
+	return nil, limit, counts, nil
+}
+
+// collapseRepeats makes every element that appears below itself use the
+// subtree of its lower occurrence, which only makes the tree lower. The node
+// keeps the step that expanded it but takes over the recipe step of the
+// occurrence along with its subtree.
+func collapseRepeats(root *tree) {
+	var collapse func(node *tree, depth int)
+	collapse = func(node *tree, depth int) {
+		for {
+			repeat := findBelow(node, node.now)
+			if repeat == nil {
+				break
+			}
+			node.children, node.childCount = repeat.children, repeat.childCount
+			node.steps = []int{node.steps[0], repeat.steps[1]}
+		}
+
+		node.depth = depth
+		for _, child := range node.children {
+			child.parent = node
+			collapse(child, depth+1)
+		}
+	}
+	collapse(root, 0)
+}
+
+// findBelow returns a strict descendant of node named name, or nil
+func findBelow(node *tree, name string) *tree {
+	for _, child := range node.children {
+		if child.now == name {
+			return child
+		}
+		if found := findBelow(child, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// iddfsSearch finds a lowest tree for target with iterative deepening and
+// lays it out
+func iddfsSearch(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, maxDepth int, stats *SearchStats) ([]ImageInfo, []LineInfo, error) {
+	root, depth, counts, err := iddfs(ctx, g, target, maxDepth, stats)
+	stats.add(counts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("IDDFS for %s stopped at depth %d: %w", target, depth, err)
+	}
+	if root == nil {
+		return nil, nil, fmt.Errorf("no recipe for %s within depth %d", target, depth)
+	}
+	fmt.Printf("IDDFS found %s at depth %d after expanding %d nodes\n", target, depth, counts.expanded)
+	stats.keep(root)
+
+	defer stats.addLayout(time.Now())
+	recipe := layoutRecipeTree(c, root)
+	return recipe.Images, recipe.Lines, nil
+}
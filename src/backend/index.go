@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WARM_UP builds the shortest recipe index of every dataset as it loads, so
+// the Shortest DFS and BFS searches become lookups
+var WARM_UP = false
+
+// RECIPE_BOOK_PATH is where the index is persisted between runs, "" to keep
+// it in memory only
+var RECIPE_BOOK_PATH = ""
+
+// loadWarmUp returns WARM_UP and RECIPE_BOOK_PATH with the values given
+// through WARM_UP (e.g. "1") and RECIPE_BOOK_PATH applied
+func loadWarmUp() (bool, string) {
+	warmUp, path := WARM_UP, RECIPE_BOOK_PATH
+
+	if env := os.Getenv("WARM_UP"); env != "" {
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			fmt.Printf("Invalid WARM_UP %q, using %t\n", env, warmUp)
+		} else {
+			warmUp = parsed
+		}
+	}
+	if env, ok := os.LookupEnv("RECIPE_BOOK_PATH"); ok {
+		path = env
+	}
+
+	return warmUp, path
+}
+
+// RecipeBook is the shortest recipe tree of every element that can be made.
+// Each tree is stored as the recipe at its root, the trees of the
+// ingredients being in their own entries.
+type RecipeBook struct {
+	Version     int                  `json:"version"`     // Dataset version it was built for
+	Fingerprint string               `json:"fingerprint"` // Identifies the recipes and base elements it was built from
+	Elements    map[string]BookEntry `json:"elements"`
+}
+
+// BookEntry is the shortest recipe tree of one element
+type BookEntry struct {
+	Tier   int          `json:"tier"`
+	Nodes  int          `json:"nodes"`            // Nodes of the tree
+	Recipe []string     `json:"recipe,omitempty"` // Ingredients of the recipe at the root, none for base elements
+	Steps  []graph.Step `json:"steps,omitempty"`  // Distinct combinations of the tree, ingredients first
+}
+
+// graphFingerprint identifies the recipes and base elements of g, so a
+// persisted book is only used for the data it was built from
+func graphFingerprint(g *graph.RecipeGraph) string {
+	hash := sha256.New()
+	for _, name := range g.BaseElements() {
+		fmt.Fprintf(hash, "base %s\n", name)
+	}
+	for _, name := range g.Elements() {
+		for _, pair := range g.Recipes(name) {
+			fmt.Fprintf(hash, "%s = %s + %s\n", name, pair.First, pair.Second)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+/*	Recipe book
+*
+*	Every element is made with the recipe that achieves its tier, so the
+*	shortest tree of an element is its recipe on top of the shortest trees of
+*	the ingredients. Tree sizes follow in increasing tier order in one pass.
+*	The crafting steps of each element take a walk over its own tree, which
+*	is independent of every other element, so those walks are spread over
+*	workers.
+ */
+func buildRecipeBook(g *graph.RecipeGraph, version int, workers int) *RecipeBook {
+	names := make([]string, 0, len(g.Elements()))
+	for _, name := range g.Elements() {
+		if g.Tier(name) != -1 {
+			names = append(names, name)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return g.Tier(names[i]) < g.Tier(names[j])
+	})
+
+	entries := make([]BookEntry, len(names))
+	nodes := make(map[string]int, len(names))
+	for i, name := range names {
+		entries[i] = BookEntry{Tier: g.Tier(name), Nodes: 1}
+		if pair, ok := g.BestRecipe(name); ok {
+			entries[i].Recipe = []string{pair.First, pair.Second}
+			entries[i].Nodes += nodes[pair.First] + nodes[pair.Second]
+		}
+		nodes[name] = entries[i].Nodes
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				entries[i].Steps = craftingSteps(g, names[i])
+			}
+		}()
+	}
+	for i := range names {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	book := &RecipeBook{
+		Version:     version,
+		Fingerprint: graphFingerprint(g),
+		Elements:    make(map[string]BookEntry, len(names)),
+	}
+	for i, name := range names {
+		book.Elements[name] = entries[i]
+	}
+	return book
+}
+
+// craftingSteps lists the distinct combinations of the shortest tree of
+// name, every ingredient before the results it is used in
+func craftingSteps(g *graph.RecipeGraph, name string) []graph.Step {
+	steps := make([]graph.Step, 0)
+	done := make(map[string]bool)
+
+	var craft func(name string)
+	craft = func(name string) {
+		pair, ok := g.BestRecipe(name)
+		if !ok || done[name] {
+			return
+		}
+		done[name] = true
+		craft(pair.First)
+		craft(pair.Second)
+		steps = append(steps, graph.Step{Result: name, First: pair.First, Second: pair.Second})
+	}
+	craft(name)
+	return steps
+}
+
+// readRecipeBook loads a persisted recipe book
+func readRecipeBook(path string) (*RecipeBook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var book RecipeBook
+	if err := json.Unmarshal(data, &book); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &book, nil
+}
+
+// writeRecipeBook persists book. It is written next to path and renamed over
+// it, so a crash never leaves half a book behind.
+func writeRecipeBook(path string, book *RecipeBook) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	// CreateTemp makes the file private, but the book is meant to be shared
+	if err := temp.Chmod(0o644); err != nil {
+		temp.Close()
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// shortestIndex answers the Shortest DFS and BFS searches of one dataset
+// snapshot from its recipe book
+type shortestIndex struct {
+	book *RecipeBook
+}
+
+// warmUpIndex returns the index of g, reading it from RECIPE_BOOK_PATH when
+// it was persisted for the same data and building it otherwise
+func warmUpIndex(g *graph.RecipeGraph, version int) *shortestIndex {
+	start := time.Now()
+	fingerprint := graphFingerprint(g)
+
+	if RECIPE_BOOK_PATH != "" {
+		book, err := readRecipeBook(RECIPE_BOOK_PATH)
+		if err == nil && book.Fingerprint == fingerprint {
+			book.Version = version
+			fmt.Printf("Read the recipe book of %d elements from %s in %s\n", len(book.Elements), RECIPE_BOOK_PATH, time.Since(start))
+			return &shortestIndex{book: book}
+		}
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println("Ignoring the persisted recipe book:", err)
+		}
+	}
+
+	book := buildRecipeBook(g, version, SEARCH_WORKERS)
+	fmt.Printf("Built the recipe book of %d elements in %s\n", len(book.Elements), time.Since(start))
+
+	if RECIPE_BOOK_PATH != "" {
+		if err := writeRecipeBook(RECIPE_BOOK_PATH, book); err != nil {
+			fmt.Println("Failed to persist the recipe book:", err)
+		}
+	}
+	return &shortestIndex{book: book}
+}
+
+// tree returns the shortest tree of target, or nil when the book has none
+func (x *shortestIndex) tree(target string) *tree {
+	if _, ok := x.book.Elements[target]; !ok {
+		return nil
+	}
+
+	var build func(name string, depth int, parent *tree) *tree
+	build = func(name string, depth int, parent *tree) *tree {
+		node := &tree{now: name, depth: depth, parent: parent}
+		if recipe := x.book.Elements[name].Recipe; len(recipe) == 2 {
+			node.children = []*tree{
+				build(recipe[0], depth+1, node),
+				build(recipe[1], depth+1, node),
+			}
+			node.childCount = 2
+		}
+		return node
+	}
+	return build(target, 0, nil)
+}
+
+// handleRecipeBook exports the recipe book of the current dataset, building
+// it when the server runs without warm-up
+func handleRecipeBook(c *gin.Context) {
+	ds := currentDataset.Load()
+
+	var book *RecipeBook
+	if ds.index != nil {
+		book = ds.index.book
+	} else {
+		book = buildRecipeBook(ds.graph, ds.version, SEARCH_WORKERS)
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="recipe_book.json"`)
+	c.JSON(http.StatusOK, book)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// searchLimits caps the work and the answer of one multi-recipe search so a
+// single pathological query cannot take over the server. Zero means no cap.
+type searchLimits struct {
+	nodes     int // nodes given a recipe, backtracking included
+	recipes   int // recipes returned
+	treeNodes int // nodes over all returned trees, which bounds the response size
+}
+
+// SEARCH_LIMITS are the caps of every multi-recipe search
+var SEARCH_LIMITS = searchLimits{
+	nodes:     2000000,
+	recipes:   1000,
+	treeNodes: 250000,
+}
+
+// loadSearchLimits returns SEARCH_LIMITS with the caps given through
+// MAX_SEARCH_NODES, MAX_RECIPES and MAX_RESPONSE_NODES applied; "0" disables
+// a cap.
+func loadSearchLimits() searchLimits {
+	limits := SEARCH_LIMITS
+
+	for _, setting := range []struct {
+		env   string
+		limit *int
+	}{
+		{"MAX_SEARCH_NODES", &limits.nodes},
+		{"MAX_RECIPES", &limits.recipes},
+		{"MAX_RESPONSE_NODES", &limits.treeNodes},
+	} {
+		env := os.Getenv(setting.env)
+		if env == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(env)
+		if err != nil || parsed < 0 {
+			fmt.Printf("Invalid %s %q, using %d\n", setting.env, env, *setting.limit)
+			continue
+		}
+		*setting.limit = parsed
+	}
+
+	return limits
+}
+
+// Truncation reports a multi-recipe search that stopped at one of its
+// limits. It is returned as an error together with the trees found so far.
+type Truncation struct {
+	Limit     string `json:"limit"`      // Cap that was hit: nodes, recipes or response_size
+	Requested int    `json:"requested"`  // Recipes asked for
+	Returned  int    `json:"returned"`   // Recipes found before stopping
+	Expanded  int    `json:"expanded"`   // Nodes given a recipe, backtracking included
+	TreeNodes int    `json:"tree_nodes"` // Nodes over all returned trees
+}
+
+func (t *Truncation) Error() string {
+	return fmt.Sprintf("search stopped at the %s limit with %d of %d recipes after expanding %d nodes",
+		t.Limit, t.Returned, t.Requested, t.Expanded)
+}
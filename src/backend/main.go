@@ -1,7 +1,8 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,10 +10,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"time"
+
+	"scraper/graph"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,11 +24,9 @@ import (
 var INITIALIZED bool = false
 var RECIPES_PATH string = "data/recipes.csv"
 var IMAGES_PATH string = "data/images.csv"
-
-type pair struct {
-	First  string
-	Second string
-}
+var IMAGES_DIR string = "data/images"
+var ALIASES_PATH string = "data/aliases.csv"
+var BASE_PATH string = "data/base_elements.csv"
 
 const MINSEP_X = 100 // Horizontal spacing
 const MINSEP_Y = 100 // Vertical spacing
@@ -62,6 +63,8 @@ type tree struct {
 	posY     int   // Final y position
 	thread   *tree // Thread to next node in contour
 	ancestor *tree // For ancestor optimization
+
+	steps []int // Trace steps that built the node, -1 for steps left out
 }
 
 type ContourNode struct {
@@ -95,24 +98,38 @@ type LineInfo struct {
 }
 
 type Response struct {
-	Images []ImageInfo `json:"images"`
-	Lines  []LineInfo  `json:"lines"`
+	Images     []ImageInfo       `json:"images"`
+	Lines      []LineInfo        `json:"lines"`
+	Recipes    []RecipeTree      `json:"recipes,omitempty"`    // Every tree of a multi-recipe search
+	Cost       *float64          `json:"cost,omitempty"`       // Price of the tree found by the Cost method
+	Efficiency *SearchEfficiency `json:"efficiency,omitempty"` // Work done by the AStar method
+	Required   []string          `json:"required,omitempty"`   // New elements needed beyond the inventory
+	Partial    bool              `json:"partial,omitempty"`    // The search timed out and Recipes holds what it found until then
+	Truncated  *Truncation       `json:"truncated,omitempty"`  // The search hit a limit and Recipes holds what it found until then
+	Stats      *SearchStats      `json:"stats,omitempty"`      // Work done by the search
+	Trace      *SearchTrace      `json:"trace,omitempty"`      // Every step of the search, when asked for
+	Cached     bool              `json:"cached,omitempty"`     // The answer comes from the search cache, Stats are those of the original search
 }
 
 type requestData struct {
-	Target        string `json:"target"`
-	Method        string `json:"method"`
-	Option        string `json:"option"`
-	NumOfRecipes  int    `json:"num_of_recipes"`
-	IncludeHigher bool   `json:"include_higher"`
+	Target        string             `json:"target"`
+	Method        string             `json:"method"`
+	Option        string             `json:"option"`
+	NumOfRecipes  int                `json:"num_of_recipes"`
+	IncludeHigher bool               `json:"include_higher"`
+	BaseElements  []string           `json:"base_elements"` // Overrides the dataset's base elements
+	Inventory     []string           `json:"inventory"`     // Elements the player already has
+	Objective     string             `json:"objective"`     // What the Cost method minimizes: combinations, leaves or cost
+	Costs         map[string]float64 `json:"costs"`         // Element -> price, for the cost objective
+	RecipeCosts   map[string]float64 `json:"recipe_costs"`  // "First + Second" -> price, for the cost objective
+	MaxDepth      int                `json:"max_depth"`     // Deepest level below the target, 0 for no limit
+	Exclude       []string           `json:"exclude"`       // Elements the tree may not use at all
+	Require       []string           `json:"require"`       // Elements the tree must contain
+	Deterministic *bool              `json:"deterministic"` // Stable node ids and trees for a request, on unless false
+	Trace         bool               `json:"trace"`         // Return every step of the search in Response.Trace
 	// nanti tambahin tambahin terserah
 }
 
-var recipes map[string][]pair = make(map[string][]pair)
-var nextElements map[string][]string = make(map[string][]string)
-var imagesLink map[string]string = make(map[string]string)
-var distances map[string]int = make(map[string]int)
-
 // getImageURL dynamically generates the image URL based on the request host
 func getImageURL(c *gin.Context, imageName string) string {
 	scheme := "http"
@@ -199,98 +216,6 @@ func runScraperProcess() error {
 	return nil
 }
 
-func readRecipes() {
-	fmt.Println("Reading recipes from", RECIPES_PATH)
-
-	file, err := os.Open(RECIPES_PATH)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	// Create a new CSV reader
-	reader := csv.NewReader(file)
-
-	// Read all records
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Loop through records
-	for _, record := range records {
-		result := record[0]
-
-		ingredient1 := record[1]
-		ingredient2 := record[2]
-
-		recipes[result] = append(recipes[result], pair{ingredient1, ingredient2})
-		nextElements[ingredient1] = append(nextElements[ingredient1], result)
-		nextElements[ingredient2] = append(nextElements[ingredient2], result)
-
-		distances[result] = -1
-		distances[ingredient1] = -1
-		distances[ingredient2] = -1
-	}
-
-	fmt.Println("Recipes loaded successfully")
-}
-
-func readImages() {
-	file, err := os.Open(IMAGES_PATH)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	// Create a new CSV reader
-	reader := csv.NewReader(file)
-
-	// Read all records
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Loop through records
-	for _, record := range records {
-		item := record[0]
-		link := record[1]
-
-		//change space of item to underscore
-		item = strings.ReplaceAll(item, " ", "_")
-
-		imagesLink[item] = link
-	}
-}
-
-func findAllDistances() {
-	fmt.Println("Finding all distances")
-
-	distances["Air"] = 0
-	distances["Water"] = 0
-	distances["Earth"] = 0
-	distances["Fire"] = 0
-	distances["Time"] = 0
-
-	for i := 0; i < 20; i++ {
-		for key, value := range recipes {
-			if distances[key] == -1 {
-				min_distance := 1000000
-				for _, pair := range value {
-					if distances[pair.First] != -1 && distances[pair.Second] != -1 {
-						min_distance = min(min_distance, max(distances[pair.First], distances[pair.Second])+1)
-					}
-				}
-
-				if min_distance != 1000000 {
-					distances[key] = min_distance
-				}
-			}
-		}
-	}
-}
-
 func INITIALIZE() {
 	if INITIALIZED == false {
 		INITIALIZED = true
@@ -307,28 +232,13 @@ func INITIALIZE() {
 			}
 		}
 
-		var wg sync.WaitGroup
-		doneFuncA := make(chan struct{})
-
-		wg.Add(3)
-		go func() {
-			defer wg.Done()
-			readRecipes()
-			close(doneFuncA)
-		}()
-
-		go func() {
-			defer wg.Done()
-			readImages()
-		}()
+		fmt.Println("Reading recipes from", RECIPES_PATH)
 
-		go func() {
-			defer wg.Done()
-			<-doneFuncA
-			findAllDistances()
-		}()
+		if _, err := loadDataset(); err != nil {
+			log.Fatalf("Failed to load recipes: %v", err)
+		}
 
-		wg.Wait()
+		fmt.Println("Recipes loaded successfully")
 	}
 }
 
@@ -605,855 +515,487 @@ func collectTree(node *tree, result *[]*tree) {
 	}
 }
 
-func singleDFS(c *gin.Context, target string) ([]ImageInfo, []LineInfo) {
-	countId := 0
-
-	type SafeTree struct {
-		stack        []*tree
-		existingTree []*tree
-		mu           sync.Mutex
-	}
-
-	Tree := &tree{now: target}
-	safe := &SafeTree{
-		stack:        []*tree{Tree},
-		existingTree: []*tree{},
-	}
-
-	for len(safe.stack) > 0 {
-		var wg sync.WaitGroup
-
-		// Extract up to 4 nodes from the end of the stack
-		safe.mu.Lock()
-		batchSize := min(4, len(safe.stack))
-		batch := make([]*tree, batchSize)
-		copy(batch, safe.stack[len(safe.stack)-batchSize:])
-		safe.stack = safe.stack[:len(safe.stack)-batchSize]
-		safe.mu.Unlock()
-
-		//fmt.Println("Stack size:", len(safe.stack))
-
-		wg.Add(len(batch))
-
-		for _, node := range batch {
-			go func(n *tree) {
-				defer wg.Done()
-
-				safe.mu.Lock()
-				n.id = countId
-				countId++
-				safe.mu.Unlock()
-
-				// DFS step: add children to the stack
-				for _, pair := range recipes[n.now] {
-					if max(distances[pair.First], distances[pair.Second]) < distances[n.now] {
-						left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-						right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-						n.children = append(n.children, left, right)
-						n.childCount += 2
-
-						safe.mu.Lock()
-						safe.stack = append(safe.stack, right, left) // Push in reverse order
-						safe.mu.Unlock()
-						break
-					}
-				}
-			}(node)
+// shortestDFSStep expands a node with the recipe that achieved its tier, like
+// shortestBFSStep, but returns the ingredients so the first one is taken next
+func shortestDFSStep(g *graph.RecipeGraph) func(n *tree) []*tree {
+	return func(n *tree) []*tree {
+		pair, ok := g.BestRecipe(n.now)
+		if !ok {
+			return nil
 		}
-		wg.Wait()
+		left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
+		right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
+		n.children = append(n.children, left, right)
+		n.childCount += 2
+		return []*tree{right, left} // Push in reverse order
 	}
+}
 
-	getTidyTree(Tree, &safe.existingTree)
-
-	//fmt.Println("Tidy tree generated. Total nodes:", len(safe.existingTree))
-
-	// Generate image info
-	images := make([]ImageInfo, 0)
-	// Generate Line info
-	lines := make([]LineInfo, 0)
-
-	// BFS to generate images
-	stack := make([]*tree, 0)
-	stack = append(stack, Tree)
-	for len(stack) > 0 {
-		node := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-
-		//fmt.Println("Processing node:", node.now, "ID:", node.id)
-
-		images = append(images, ImageInfo{
-			Link: getImageURL(c, strings.ReplaceAll(node.now, " ", "_")),
-			Row:  node.posY,
-			Col:  node.posX,
-			Name: node.now,
-			Id:   node.id,
-		})
-
-		for i := 0; i < node.childCount; i += 2 {
-			left := node.children[i]
-			right := node.children[i+1]
-
-			lines = append(lines, LineInfo{
-				From_x:  left.posX,
-				From_y:  left.posY,
-				From_Id: left.id,
-				To_x:    right.posX,
-				To_y:    right.posY,
-				To_Id:   right.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  (right.posX + left.posX) / 2,
-				From_y:  right.posY,
-				From_Id: right.id,
-				To_x:    node.posX,
-				To_y:    node.posY,
-				To_Id:   left.id,
-			})
-		}
-
-		for _, child := range node.children {
-			stack = append(stack, child)
+// shortestBFSStep expands a node with the recipe that achieved its tier
+func shortestBFSStep(g *graph.RecipeGraph) func(n *tree) []*tree {
+	return func(n *tree) []*tree {
+		if pair, ok := g.BestRecipe(n.now); ok {
+			left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
+			right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
+			n.children = append(n.children, left, right)
+			n.childCount += 2
+			return []*tree{left, right}
 		}
+		return nil
 	}
-
-	return images, lines
 }
 
-func multiDFS(c *gin.Context, target string, count int, includeHigher bool) ([]ImageInfo, []LineInfo) {
-	countId := 0
-	counter := int32(0)
-
-	type SafeTree struct {
-		stack        []*tree
-		existingTree []*tree
-		mu           sync.Mutex
-	}
-
-	Tree := &tree{now: target}
-	safe := &SafeTree{
-		stack:        []*tree{Tree},
-		existingTree: []*tree{},
-	}
-
-	for len(safe.stack) > 0 {
-		var wg sync.WaitGroup
-
-		// Extract up to 4 nodes from the end of the stack (LIFO for DFS)
-		safe.mu.Lock()
-		batchSize := min(4, len(safe.stack))
-		batch := make([]*tree, batchSize)
-		copy(batch, safe.stack[len(safe.stack)-batchSize:])
-		safe.stack = safe.stack[:len(safe.stack)-batchSize]
-		safe.mu.Unlock()
-
-		wg.Add(len(batch))
-
-		for _, node := range batch {
-			go func(n *tree) {
-				defer wg.Done()
-
-				safe.mu.Lock()
-				n.id = countId
-				countId++
-				safe.mu.Unlock()
-
-				// DFS step: add children to the stack
-				for _, pair := range recipes[n.now] {
-					if includeHigher {
-						if atomic.LoadInt32(&counter) < int32(count)-1 {
-							atomic.AddInt32(&counter, 1)
-
-							left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-							right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-							n.children = append(n.children, left, right)
-							n.childCount += 2
-
-							safe.mu.Lock()
-							// Push in reverse order for DFS
-							safe.stack = append(safe.stack, right, left)
-							safe.mu.Unlock()
-						} else {
-							if max(distances[pair.First], distances[pair.Second]) < distances[n.now] {
-								left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-								right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-								n.children = append(n.children, left, right)
-								n.childCount += 2
-
-								safe.mu.Lock()
-								safe.stack = append(safe.stack, right, left)
-								safe.mu.Unlock()
-								break
-							}
-						}
-					} else {
-						if max(distances[pair.First], distances[pair.Second]) < distances[n.now] && atomic.LoadInt32(&counter) < int32(count)-1 {
-							left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-							right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-							n.children = append(n.children, left, right)
-							n.childCount += 2
-
-							safe.mu.Lock()
-							safe.stack = append(safe.stack, right, left)
-							safe.mu.Unlock()
-						} else {
-							if max(distances[pair.First], distances[pair.Second]) < distances[n.now] {
-								left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-								right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-								n.children = append(n.children, left, right)
-								n.childCount += 2
-
-								safe.mu.Lock()
-								safe.stack = append(safe.stack, right, left)
-								safe.mu.Unlock()
-								break
-							}
-						}
-					}
-				}
-			}(node)
+// shortestTree returns the shortest tree of target, numbered in the order a
+// depth first or breadth first search takes its nodes. It is read from index
+// when there is one and searched on the worker pool otherwise. A lookup has
+// no steps to show, so a traced or streamed search always runs.
+func shortestTree(ctx context.Context, g *graph.RecipeGraph, target string, depthFirst bool, deterministic bool, index *shortestIndex, stats *SearchStats) (*tree, error) {
+	if index != nil && stats.trace == nil && stats.listen == nil {
+		if root := index.tree(target); root != nil {
+			stats.Indexed = true
+			numberInOrder(root, depthFirst)
+			return root, nil
 		}
-		wg.Wait()
 	}
 
-	getTidyTree(Tree, &safe.existingTree)
-
-	// Generate image info
-	images := make([]ImageInfo, 0)
-	// Generate Line info
-	lines := make([]LineInfo, 0)
-
-	// DFS to generate images
-	stack := make([]*tree, 0)
-	stack = append(stack, Tree)
-	for len(stack) > 0 {
-		node := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-
-		images = append(images, ImageInfo{
-			Link: getImageURL(c, strings.ReplaceAll(node.now, " ", "_")),
-			Row:  node.posY,
-			Col:  node.posX,
-			Name: node.now,
-			Id:   node.id,
-		})
-
-		for i := 0; i < node.childCount; i += 2 {
-			left := node.children[i]
-			right := node.children[i+1]
-
-			lines = append(lines, LineInfo{
-				From_x:  left.posX,
-				From_y:  left.posY,
-				From_Id: left.id,
-				To_x:    right.posX,
-				To_y:    right.posY,
-				To_Id:   right.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  (right.posX + left.posX) / 2,
-				From_y:  right.posY,
-				From_Id: right.id,
-				To_x:    (right.posX + left.posX) / 2,
-				To_y:    right.posY + 50,
-				To_Id:   left.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  (right.posX + left.posX) / 2,
-				From_y:  right.posY + 50,
-				From_Id: left.id,
-				To_x:    node.posX,
-				To_y:    right.posY + 50,
-				To_Id:   right.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  node.posX,
-				From_y:  right.posY + 50,
-				From_Id: left.id,
-				To_x:    node.posX,
-				To_y:    node.posY,
-				To_Id:   right.id,
-			})
-		}
-
-		// Push children in reverse order for DFS
-		for i := node.childCount - 1; i >= 0; i-- {
-			stack = append(stack, node.children[i])
-		}
+	// Expand nodes from a shared stack or queue on the worker pool
+	root := &tree{now: target}
+	step := shortestBFSStep(g)
+	if depthFirst {
+		step = shortestDFSStep(g)
 	}
-
-	return images, lines
-}
-
-func singleBFS(c *gin.Context, target string) ([]ImageInfo, []LineInfo) {
-	countId := 0
-
-	type SafeTree struct {
-		queue        []*tree
-		existingTree []*tree
-		mu           sync.Mutex
+	engine := expansionEngine{workers: SEARCH_WORKERS, depthFirst: depthFirst, stats: stats}
+	counts, err := engine.run(ctx, root, step)
+	stats.add(counts)
+	if err != nil {
+		return nil, err
 	}
-
-	Tree := &tree{now: target}
-	safe := &SafeTree{
-		queue:        []*tree{Tree},
-		existingTree: []*tree{},
+	if deterministic {
+		numberInOrder(root, depthFirst)
 	}
+	return root, nil
+}
 
-	for len(safe.queue) > 0 {
-		var wg sync.WaitGroup
-
-		// Extract up to 4 nodes from the queue
-		safe.mu.Lock()
-		batchSize := min(4, len(safe.queue))
-		batch := safe.queue[:batchSize]
-		safe.queue = safe.queue[batchSize:]
-		safe.mu.Unlock()
-
-		wg.Add(len(batch))
+// singleShortest lays out the shortest tree of target, keeping the node ids
+// in the order the search took the nodes
+func singleShortest(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, depthFirst bool, deterministic bool, index *shortestIndex, stats *SearchStats) ([]ImageInfo, []LineInfo, error) {
+	root, err := shortestTree(ctx, g, target, depthFirst, deterministic, index, stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats.keep(root)
 
-		for _, node := range batch {
-			go func(n *tree) {
-				defer wg.Done()
+	defer stats.addLayout(time.Now())
+	recipe := layoutNumberedTree(c, root)
+	return recipe.Images, recipe.Lines, nil
+}
 
-				//fmt.Println("Processing node:", n.now)
+func singleDFS(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, deterministic bool, index *shortestIndex, stats *SearchStats) ([]ImageInfo, []LineInfo, error) {
+	return singleShortest(ctx, c, g, target, true, deterministic, index, stats)
+}
 
-				// Track depth
-				safe.mu.Lock()
-				n.id = countId
-				countId++
-				safe.mu.Unlock()
+func singleBFS(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, deterministic bool, index *shortestIndex, stats *SearchStats) ([]ImageInfo, []LineInfo, error) {
+	return singleShortest(ctx, c, g, target, false, deterministic, index, stats)
+}
 
-				// Handle BFS step and enqueue new nodes
-				for _, pair := range recipes[n.now] {
-					if max(distances[pair.First], distances[pair.Second])+1 == distances[n.now] {
-						left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-						right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-						n.children = append(n.children, left, right)
-						n.childCount += 2
+// searchRequest is a requestData that has been checked and resolved against
+// one dataset snapshot
+type searchRequest struct {
+	requestData
+	target        string             // Resolved target element
+	inventory     []string           // Resolved inventory elements
+	require       []string           // Resolved required elements
+	exclude       []string           // Resolved excluded elements
+	deterministic bool               // Same request, same answer
+	version       int                // Version of the dataset snapshot
+	index         *shortestIndex     // Shortest trees of graph, nil when there are none
+	graph         *graph.RecipeGraph // Graph to search, with custom base elements and exclusions applied
+	cost          graph.CostModel    // What the Cost method minimizes
+}
 
-						safe.mu.Lock()
-						safe.queue = append(safe.queue, left, right)
-						safe.mu.Unlock()
-						break
-					}
-				}
-			}(node)
-		}
-		wg.Wait() // Wait for this batch to finish
+// bindSearchRequest parses and resolves the request body. When it returns
+// false the request has already been answered with an error.
+func bindSearchRequest(c *gin.Context) (*searchRequest, bool) {
+	var data requestData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		fmt.Println("Binding failed:", err)
+		return nil, false
 	}
+	fmt.Println(data)
 
-	getTidyTree(Tree, &safe.existingTree)
-
-	// Generate image info
-	images := make([]ImageInfo, 0)
-	// Generate Line info
-	lines := make([]LineInfo, 0)
+	if strings.TrimSpace(data.Target) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target is required"})
+		return nil, false
+	}
+	if data.MaxDepth < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_depth cannot be negative"})
+		return nil, false
+	}
 
-	// BFS to generate images
-	queue := make([]*tree, 0)
-	queue = append(queue, Tree)
-	for len(queue) > 0 {
-		node := queue[0]
-		queue = queue[1:]
+	// Pin the snapshot for the whole request so a concurrent reload cannot
+	// change the data halfway through a search
+	ds := currentDataset.Load()
+	g := ds.graph
 
-		images = append(images, ImageInfo{
-			Link: getImageURL(c, strings.ReplaceAll(node.now, " ", "_")),
-			Row:  node.posY,
-			Col:  node.posX,
-			Name: node.now,
-			Id:   node.id,
+	target, suggestions, ok := ds.resolver.Resolve(data.Target)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":       fmt.Sprintf("element %q not found", data.Target),
+			"target":      data.Target,
+			"suggestions": suggestions,
 		})
-
-		for i := 0; i < node.childCount; i += 2 {
-			left := node.children[i]
-			right := node.children[i+1]
-
-			lines = append(lines, LineInfo{
-				From_x:  left.posX,
-				From_y:  left.posY,
-				From_Id: left.id,
-				To_x:    right.posX,
-				To_y:    right.posY,
-				To_Id:   right.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  (right.posX + left.posX) / 2,
-				From_y:  right.posY,
-				From_Id: right.id,
-				To_x:    node.posX,
-				To_y:    node.posY,
-				To_Id:   left.id,
-			})
-		}
-
-		for _, child := range node.children {
-			queue = append(queue, child)
-		}
+		return nil, false
 	}
 
-	return images, lines
-}
+	base, ok := resolveNames(c, ds.resolver, data.BaseElements, "base element")
+	if !ok {
+		return nil, false
+	}
+	inventory, ok := resolveNames(c, ds.resolver, data.Inventory, "inventory element")
+	if !ok {
+		return nil, false
+	}
+	exclude, ok := resolveNames(c, ds.resolver, data.Exclude, "excluded element")
+	if !ok {
+		return nil, false
+	}
+	require, ok := resolveNames(c, ds.resolver, data.Require, "required element")
+	if !ok {
+		return nil, false
+	}
 
-func multiBFS(c *gin.Context, target string, count int, includeHigher bool) ([]ImageInfo, []LineInfo) {
-	countId := 0
-	counter := int32(0)
-
-	type SafeTree struct {
-		queue        []*tree
-		existingTree []*tree
-		mu           sync.Mutex
-	}
-
-	Tree := &tree{now: target}
-	safe := &SafeTree{
-		queue:        []*tree{Tree},
-		existingTree: []*tree{},
-	}
-
-	for len(safe.queue) > 0 {
-		var wg sync.WaitGroup
-
-		// Extract up to 4 nodes from the queue
-		safe.mu.Lock()
-		batchSize := min(4, len(safe.queue))
-		batch := safe.queue[:batchSize]
-		safe.queue = safe.queue[batchSize:]
-		safe.mu.Unlock()
-
-		wg.Add(len(batch))
-
-		for _, node := range batch {
-			go func(n *tree) {
-				defer wg.Done()
-
-				// Track depth
-				safe.mu.Lock()
-				n.id = countId
-				countId++
-				safe.mu.Unlock()
-
-				// Handle BFS step and enqueue new nodes
-				for _, pair := range recipes[n.now] {
-					if includeHigher {
-						if atomic.LoadInt32(&counter) < int32(count)-1 {
-							atomic.AddInt32(&counter, 1)
-
-							left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-							right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-							n.children = append(n.children, left, right)
-							n.childCount += 2
-
-							safe.mu.Lock()
-							safe.queue = append(safe.queue, left, right)
-							safe.mu.Unlock()
-						} else {
-							if max(distances[pair.First], distances[pair.Second])+1 == distances[n.now] {
-								left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-								right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-								n.children = append(n.children, left, right)
-								n.childCount += 2
-
-								safe.mu.Lock()
-								safe.queue = append(safe.queue, left, right)
-								safe.mu.Unlock()
-								break
-							}
-						}
-					} else {
-						if max(distances[pair.First], distances[pair.Second])+1 <= distances[n.now] && atomic.LoadInt32(&counter) < int32(count)-1 {
-							atomic.AddInt32(&counter, 1)
-
-							left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-							right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-							n.children = append(n.children, left, right)
-							n.childCount += 2
-
-							safe.mu.Lock()
-							safe.queue = append(safe.queue, left, right)
-							safe.mu.Unlock()
-						} else {
-							if max(distances[pair.First], distances[pair.Second])+1 == distances[n.now] {
-								left := &tree{now: pair.First, depth: n.depth + 1, parent: n}
-								right := &tree{now: pair.Second, depth: n.depth + 1, parent: n}
-								n.children = append(n.children, left, right)
-								n.childCount += 2
-
-								safe.mu.Lock()
-								safe.queue = append(safe.queue, left, right)
-								safe.mu.Unlock()
-								break
-							}
-						}
-					}
-				}
-			}(node)
+	for _, name := range exclude {
+		if name == target || slices.Contains(require, name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s cannot be both needed and excluded", name)})
+			return nil, false
 		}
-		wg.Wait() // Wait for this batch to finish
 	}
 
-	getTidyTree(Tree, &safe.existingTree)
-
-	// Generate image info
-	images := make([]ImageInfo, 0)
-	// Generate Line info
-	lines := make([]LineInfo, 0)
-
-	// BFS to generate images
-	queue := make([]*tree, 0)
-	queue = append(queue, Tree)
-	for len(queue) > 0 {
-		node := queue[0]
-		queue = queue[1:]
-
-		images = append(images, ImageInfo{
-			Link: getImageURL(c, strings.ReplaceAll(node.now, " ", "_")),
-			Row:  node.posY,
-			Col:  node.posX,
-			Name: node.now,
-			Id:   node.id,
-		})
-
-		for i := 0; i < node.childCount; i += 2 {
-			left := node.children[i]
-			right := node.children[i+1]
-
-			//fmt.Println(left.id, " -> ", node.id)
-			//fmt.Println(right.id, " -> ", node.id)
-
-			lines = append(lines, LineInfo{
-				From_x:  left.posX,
-				From_y:  left.posY,
-				From_Id: left.id,
-				To_x:    right.posX,
-				To_y:    right.posY,
-				To_Id:   right.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  (right.posX + left.posX) / 2,
-				From_y:  right.posY,
-				From_Id: right.id,
-				To_x:    (right.posX + left.posX) / 2,
-				To_y:    right.posY + 50,
-				To_Id:   left.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  (right.posX + left.posX) / 2,
-				From_y:  right.posY + 50,
-				From_Id: left.id,
-				To_x:    node.posX,
-				To_y:    right.posY + 50,
-				To_Id:   right.id,
-			})
-
-			lines = append(lines, LineInfo{
-				From_x:  node.posX,
-				From_y:  right.posY + 50,
-				From_Id: left.id,
-				To_x:    node.posX,
-				To_y:    node.posY,
-				To_Id:   right.id,
-			})
+	// Inventory elements are already discovered, so they are as free as
+	// the base elements: both become tier 0 leaves for every method
+	if len(base) > 0 || len(inventory) > 0 {
+		if len(base) == 0 {
+			base = g.BaseElements()
 		}
+		start := append(append([]string{}, base...), inventory...)
 
-		for _, child := range node.children {
-			queue = append(queue, child)
+		derived, err := g.WithBaseElements(start)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
 		}
+		g = derived
 	}
 
-	return images, lines
-}
-
-func BidirectionalSearch(c *gin.Context, target string) ([]ImageInfo, []LineInfo) {
-	visitedBySource := make(map[string]bool)
-	visitedByTarget := make(map[string]bool)
-
-	var IdCount int32
-	atomic.StoreInt32(&IdCount, 0)
-
-	MapTree := make(map[string]*tree)
-	for key := range recipes {
-		MapTree[key] = &tree{now: key}
-	}
-	MapTree["Earth"] = &tree{now: "Earth"}
-	MapTree["Time"] = &tree{now: "Time"}
-
-	queueSource := []*tree{MapTree["Earth"], MapTree["Water"], MapTree["Air"], MapTree["Fire"], MapTree["Time"]}
-	queueTarget := []*tree{MapTree[target]}
-
-	MapTree["Earth"].id = int(atomic.AddInt32(&IdCount, 1))
-	MapTree["Time"].id = int(atomic.AddInt32(&IdCount, 1))
-	MapTree["Air"].id = int(atomic.AddInt32(&IdCount, 1))
-	MapTree["Water"].id = int(atomic.AddInt32(&IdCount, 1))
-	MapTree["Fire"].id = int(atomic.AddInt32(&IdCount, 1))
-	MapTree[target].id = int(atomic.AddInt32(&IdCount, 1))
-
-	visitOrder := make([]*tree, 0)
-	visitOrder = append(visitOrder, MapTree["Earth"], MapTree["Water"], MapTree["Air"], MapTree["Fire"], MapTree["Time"], MapTree[target])
-	var visitOrderMu sync.Mutex
-
-	// Mutexes for safe concurrent map access
-	var muSource sync.RWMutex
-	var muTarget sync.RWMutex
-
-	for len(queueSource) > 0 && len(queueTarget) > 0 {
-		var wg sync.WaitGroup
-		wg.Add(2)
-
-		// BFS from source
-		go func() {
-			defer wg.Done()
-			for len(queueSource) > 0 {
-				node := queueSource[0]
-				queueSource = queueSource[1:]
-
-				muSource.RLock()
-				muTarget.RLock()
-				if visitedBySource[node.now] || len(node.children) > 0 {
-					muSource.RUnlock()
-					muTarget.RUnlock()
-					continue
-				}
-				muTarget.RUnlock()
-				muSource.RUnlock()
-
-				muSource.Lock()
-				visitedBySource[node.now] = true
-				muSource.Unlock()
-
-				for _, next := range nextElements[node.now] {
-					for _, pair := range recipes[next] {
-						muSource.RLock()
-						can1 := visitedBySource[pair.First]
-						can2 := visitedBySource[pair.Second]
-						muSource.RUnlock()
-
-						if can1 && can2 {
-							queueSource = append(queueSource, MapTree[next])
-
-							MapTree[next].depth = node.depth + 1
-							MapTree[next].parent = node
-							MapTree[next].children = append(MapTree[next].children, MapTree[pair.First], MapTree[pair.Second])
-							MapTree[next].id = int(atomic.AddInt32(&IdCount, 1))
-
-							fmt.Println(next, " -> ", pair.First, pair.Second)
-							visitOrderMu.Lock()
-							visitOrder = append(visitOrder, MapTree[next])
-							visitOrderMu.Unlock()
-							break
-						}
-					}
-				}
-			}
-		}()
-
-		// BFS from target
-		go func() {
-			defer wg.Done()
-			for len(queueTarget) > 0 {
-				node := queueTarget[0]
-				queueTarget = queueTarget[1:]
-
-				muSource.RLock()
-				muTarget.RLock()
-				if visitedByTarget[node.now] || visitedBySource[node.now] {
-					muSource.RUnlock()
-					muTarget.RUnlock()
-					continue
-				}
-				muTarget.RUnlock()
-				muSource.RUnlock()
-
-				muTarget.Lock()
-				visitedByTarget[node.now] = true
-				muTarget.Unlock()
-
-				for _, pair := range recipes[node.now] {
-					d1 := distances[pair.First]
-					d2 := distances[pair.Second]
-					d := distances[node.now]
-					if max(d1, d2)+1 == d {
-						muTarget.RLock()
-						vt1 := visitedByTarget[pair.First]
-						vt2 := visitedByTarget[pair.Second]
-						muTarget.RUnlock()
-
-						if !vt1 && !vt2 {
-							queueTarget = append(queueTarget, MapTree[pair.First], MapTree[pair.Second])
-
-							visitOrderMu.Lock()
-							visitOrder = append(visitOrder, MapTree[pair.First], MapTree[pair.Second])
-							visitOrderMu.Unlock()
-
-							MapTree[pair.First].depth = node.depth + 1
-							MapTree[pair.First].parent = node
-							MapTree[pair.First].id = int(atomic.AddInt32(&IdCount, 1))
-
-							MapTree[pair.Second].depth = node.depth + 1
-							MapTree[pair.Second].parent = node
-							MapTree[pair.Second].id = int(atomic.AddInt32(&IdCount, 1))
-
-							MapTree[node.now].children = append(MapTree[node.now].children, MapTree[pair.First], MapTree[pair.Second])
-							break
-						}
-					}
-				}
-			}
-		}()
-
-		wg.Wait()
-	}
-
-	// make unique
-	uniqueVisitOrder := make([]*tree, 0, len(visitOrder))
-	seen := make(map[string]bool)
-
-	for _, node := range visitOrder {
-		if !seen[node.now] {
-			seen[node.now] = true
-			uniqueVisitOrder = append(uniqueVisitOrder, node)
+	if len(exclude) > 0 {
+		derived, err := g.Without(exclude)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
 		}
+		g = derived
 	}
 
-	for _, node := range visitOrder {
-		fmt.Println("Node:", node.now)
+	if g.Tier(target) == -1 {
+		message := fmt.Sprintf("%s cannot be made from the base elements", target)
+		if len(exclude) > 0 {
+			message = fmt.Sprintf("%s cannot be made without %s", target, strings.Join(exclude, ", "))
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":         message,
+			"base_elements": g.BaseElements(),
+		})
+		return nil, false
 	}
 
-	fmt.Println("Unique visit order:")
-	for _, node := range uniqueVisitOrder {
-		fmt.Println("Node:", node.now)
+	cost, ok := costModel(c, ds.resolver, data)
+	if !ok {
+		return nil, false
 	}
 
-	visitOrder = uniqueVisitOrder
-
-	// Output visited nodes (debug/log)
-	for _, node := range visitOrder {
-		// muSource.RLock()
-		// visitedBySrc := visitedBySource[node.now]
-		// muSource.RUnlock()
-		// if visitedBySrc {
-		// 	fmt.Println("visited by Source,", "Node:", node.now, "Depth:", node.depth)
-		// } else {
-		// 	fmt.Println("visited by Target,", "Node:", node.now, "Depth:", node.depth)
-		// }
+	// A tree that repeats no element on a path is never deeper than there
+	// are elements, and the lowest and the cheapest trees never need to, so
+	// a larger limit only costs the depth limited searches memory
+	data.MaxDepth = min(data.MaxDepth, len(g.Elements()))
 
-		fmt.Println("Node:", node.now, "Depth:", distances[node.now], "ID:", node.id)
+	// The index only holds the trees of the dataset's own graph
+	var index *shortestIndex
+	if g == ds.graph {
+		index = ds.index
 	}
 
-	images := make([]ImageInfo, 0)
-	lines := make([]LineInfo, 0)
+	return &searchRequest{
+		requestData:   data,
+		target:        target,
+		inventory:     inventory,
+		require:       require,
+		exclude:       exclude,
+		deterministic: data.Deterministic == nil || *data.Deterministic,
+		version:       ds.version,
+		index:         index,
+		graph:         g,
+		cost:          cost,
+	}, true
+}
 
-	countLevel := make(map[int]int)
-	for _, node := range visitOrder {
-		node.posX = 400 * countLevel[distances[node.now]]
-		node.posY = 400 * distances[node.now]
-		countLevel[distances[node.now]]++
+// API handlers
+func handleSearch(c *gin.Context) {
+	req, ok := bindSearchRequest(c)
+	if !ok {
+		return
 	}
 
-	for _, node := range visitOrder {
-		//normalize so that center is 0
-		node.posX -= 400 * countLevel[distances[node.now]] / 2
-		node.posY -= 400 * distances[node.now] / 2
-
-		images = append(images, ImageInfo{
-			Link: getImageURL(c, strings.ReplaceAll(node.now, " ", "_")),
-			Row:  node.posY,
-			Col:  node.posX,
-			Name: node.now,
-			Id:   node.id,
-		})
+	if failure := checkSearch(req); failure != nil {
+		failure.answer(c)
+		return
 	}
 
-	for _, node := range visitOrder {
-		if len(node.children) == 0 {
-			continue
-		}
-
-		left := node.children[0]
-		right := node.children[1]
-
-		//lines from left to node
-		lines = append(lines, LineInfo{
-			From_x:  left.posX,
-			From_y:  left.posY,
-			From_Id: left.id,
-			To_x:    node.posX,
-			To_y:    node.posY,
-			To_Id:   node.id,
-		})
-
-		//lines from right to node
-		lines = append(lines, LineInfo{
-			From_x:  right.posX,
-			From_y:  right.posY,
-			From_Id: right.id,
-			To_x:    node.posX,
-			To_y:    node.posY,
-			To_Id:   node.id,
+	// Repeated deterministic searches are answered from the cache, or share
+	// the search an identical request already started
+	stats := newSearchStats(req)
+	var response *Response
+	var failure *searchFailure
+	if req.deterministic {
+		response, failure = searchResults.search(c, searchKey(c, req, stats), req.target, func(ctx context.Context) (*Response, *searchFailure) {
+			return runSearch(ctx, c, req, stats)
 		})
+	} else {
+		// Every search stops once the client disconnects or SEARCH_TIMEOUT passes
+		ctx, cancel := searchContext(c)
+		defer cancel()
+		response, failure = runSearch(ctx, c, req, stats)
 	}
+	if failure != nil {
+		failure.answer(c)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	return images, lines
+// searchFailure is the error answer to a search request
+type searchFailure struct {
+	status int
+	body   gin.H // nil when nobody is left to read an answer
 }
 
-// API handlers
-func handleSearch(c *gin.Context) {
-	var data requestData
-	if err := c.ShouldBindJSON(&data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		fmt.Println("Binding failed:", err)
+// answer sends the failure to the client
+func (f *searchFailure) answer(c *gin.Context) {
+	if f.body == nil {
+		c.AbortWithStatus(f.status)
 		return
 	}
-	fmt.Println(data)
-
-	target := data.Target
-	method := data.Method
-	option := data.Option
-	num_of_recipes := data.NumOfRecipes
-	include_higher := data.IncludeHigher
+	c.JSON(f.status, f.body)
+}
 
-	runes := []rune(target)
-	for i := 1; i < len(runes); i++ {
-		if 'A' <= runes[i] && runes[i] <= 'Z' {
-			runes[i] = runes[i] + 32
-		}
+// checkSearch returns the error to answer req with when it asks for a
+// search that cannot succeed, before any search runs or is looked up
+func checkSearch(req *searchRequest) *searchFailure {
+	g := req.graph
+	target := req.target
+	method := req.Method
+	max_depth := req.MaxDepth
+
+	// No tree is lower than the target's tier, and the shortest searches
+	// build trees exactly that high, so this check is all they need
+	if max_depth > 0 && g.Tier(target) > max_depth {
+		return &searchFailure{http.StatusUnprocessableEntity, gin.H{
+			"error": fmt.Sprintf("no recipe for %s within depth %d", target, max_depth),
+			"tier":  g.Tier(target),
+		}}
+	}
+
+	// Only the tree searches can steer towards required elements
+	if len(req.require) > 0 && (method == "IDDFS" || method == "AStar" || method == "Cost") {
+		return &searchFailure{http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("the %s method does not support require", method),
+		}}
 	}
-	if runes[0] >= 'a' && runes[0] <= 'z' {
-		runes[0] = runes[0] - 32
+	return nil
+}
+
+// runSearch runs the search req asks for, which checkSearch accepted, recording its work in stats, and
+// returns either the response or the error to answer with
+func runSearch(ctx context.Context, c *gin.Context, req *searchRequest, stats *SearchStats) (*Response, *searchFailure) {
+	g := req.graph
+	target := req.target
+	method := req.Method
+	option := req.Option
+	num_of_recipes := req.NumOfRecipes
+	include_higher := req.IncludeHigher
+	max_depth := req.MaxDepth
+
+	opts := treeOptions{
+		includeHigher: include_higher,
+		maxDepth:      max_depth,
+		require:       req.require,
+		limits:        SEARCH_LIMITS,
 	}
-	target = string(runes)
 
 	fmt.Println("Searching for target:", target)
+	start := time.Now()
 	var images []ImageInfo
 	var lines []LineInfo
+	var recipes []RecipeTree
+	var total *float64
+	var efficiency *SearchEfficiency
+	var err error
 	if method == "DFS" {
-		if option == "Shortest" {
-			images, lines = singleDFS(c, target)
+		if option == "Shortest" && len(req.require) > 0 {
+			images, lines, err = firstRecipe(multiDFS(ctx, c, g, target, 1, opts, stats))
+		} else if option == "Shortest" {
+			images, lines, err = singleDFS(ctx, c, g, target, req.deterministic, req.index, stats)
 		} else {
-			images, lines = multiDFS(c, target, num_of_recipes, include_higher)
+			recipes, err = multiDFS(ctx, c, g, target, num_of_recipes, opts, stats)
 		}
 	} else if method == "BFS" {
-		if option == "Shortest" {
-			images, lines = singleBFS(c, target)
+		if option == "Shortest" && len(req.require) > 0 {
+			images, lines, err = firstRecipe(multiBFS(ctx, c, g, target, 1, opts, stats))
+		} else if option == "Shortest" {
+			images, lines, err = singleBFS(ctx, c, g, target, req.deterministic, req.index, stats)
 		} else {
-			images, lines = multiBFS(c, target, num_of_recipes, include_higher)
+			recipes, err = multiBFS(ctx, c, g, target, num_of_recipes, opts, stats)
+		}
+	} else if method == "IDDFS" {
+		images, lines, err = iddfsSearch(ctx, c, g, target, max_depth, stats)
+	} else if method == "AStar" {
+		images, lines, efficiency, err = astarSearch(ctx, c, g, target, max_depth, stats)
+	} else if method == "Cost" {
+		var cost *CostTree
+		cost, err = costSearch(ctx, c, g, target, req.cost, max_depth, stats)
+		if err == nil {
+			images, lines = cost.Images, cost.Lines
+			total = &cost.Cost
 		}
+	} else if option == "Multiple" {
+		recipes, err = bidirectionalSearch(ctx, c, g, target, num_of_recipes, req.require, opts.limits, req.deterministic, stats)
 	} else {
-		images, lines = BidirectionalSearch(c, target)
+		images, lines, err = firstRecipe(bidirectionalSearch(ctx, c, g, target, 1, req.require, opts.limits, req.deterministic, stats))
+	}
+	stats.finish(time.Since(start))
+
+	// A multi-recipe search that ran out of time or hit one of its limits
+	// still answers with the trees it found until then. Only those searches
+	// set recipes, and a timeout is reported as partial even before the
+	// first tree, which is no proof that there is none.
+	partial := recipes != nil && errors.Is(err, context.DeadlineExceeded)
+	var truncated *Truncation
+	if !errors.As(err, &truncated) || len(recipes) == 0 {
+		truncated = nil
+	}
+	if err != nil && !partial && truncated == nil {
+		return nil, searchFailed(target, err)
+	}
+	if partial {
+		fmt.Printf("Search for %s timed out with %d of %d recipes\n", target, len(recipes), num_of_recipes)
+	}
+	if truncated != nil {
+		fmt.Printf("Search for %s truncated: %s\n", target, truncated.Error())
+	}
+
+	// Multi-recipe searches still fill images and lines, with the first
+	// recipe, for clients that only show one tree
+	if len(recipes) > 0 {
+		images, lines = recipes[0].Images, recipes[0].Lines
+	} else if partial {
+		images, lines = make([]ImageInfo, 0), make([]LineInfo, 0)
+	}
+	stats.TreeNodes = len(images)
+	fmt.Printf("Search stats: %d nodes expanded, frontier up to %d, %.1fms search, %.1fms layout\n",
+		stats.NodesExpanded, stats.MaxFrontier, stats.SearchMs, stats.LayoutMs)
+
+	// The tree searches only return trees with every required element, so
+	// anything missing here means there is no such tree
+	if missing := missingElements(images, req.require); len(missing) > 0 && !partial {
+		return nil, &searchFailure{http.StatusUnprocessableEntity, gin.H{
+			"error":   fmt.Sprintf("no recipe for %s contains %s", target, strings.Join(missing, ", ")),
+			"missing": missing,
+		}}
+	}
+
+	response := &Response{
+		Images:     images,
+		Lines:      lines,
+		Recipes:    recipes,
+		Cost:       total,
+		Efficiency: efficiency,
+		Partial:    partial,
+		Truncated:  truncated,
+		Stats:      stats,
+		Trace:      stats.traced(),
+	}
+	if len(req.inventory) > 0 {
+		all := images
+		if len(recipes) > 1 {
+			all = make([]ImageInfo, 0)
+			for _, recipe := range recipes {
+				all = append(all, recipe.Images...)
+			}
+		}
+		response.Required = requiredElements(g, all)
 	}
 
-	response := Response{
-		Images: images,
-		Lines:  lines,
+	return response, nil
+}
+
+// resolveNames resolves every name in names, answering the request with a
+// 400 and returning false on the first one that is not an element
+func resolveNames(c *gin.Context, resolver *graph.Resolver, names []string, what string) ([]string, bool) {
+	resolved := make([]string, 0, len(names))
+	for _, name := range names {
+		element, suggestions, ok := resolver.Resolve(name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       fmt.Sprintf("%s %q not found", what, name),
+				"suggestions": suggestions,
+			})
+			return nil, false
+		}
+		resolved = append(resolved, element)
 	}
+	return resolved, true
+}
 
-	c.JSON(http.StatusOK, response)
+// firstRecipe returns the layout of the first of recipes, or nothing, passing
+// err on
+func firstRecipe(recipes []RecipeTree, err error) ([]ImageInfo, []LineInfo, error) {
+	if len(recipes) == 0 {
+		return nil, nil, err
+	}
+	return recipes[0].Images, recipes[0].Lines, err
+}
+
+// missingElements returns the names that appear nowhere in images
+func missingElements(images []ImageInfo, names []string) []string {
+	missing := make([]string, 0)
+	for _, name := range names {
+		if !slices.ContainsFunc(images, func(image ImageInfo) bool { return image.Name == name }) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// requiredElements lists the elements of a result that still have to be
+// made, i.e. everything that is not a free tier 0 leaf, lowest tier first
+func requiredElements(g *graph.RecipeGraph, images []ImageInfo) []string {
+	required := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, image := range images {
+		if seen[image.Name] || g.IsBase(image.Name) {
+			continue
+		}
+		seen[image.Name] = true
+		required = append(required, image.Name)
+	}
+
+	sort.Slice(required, func(i, j int) bool {
+		if g.Tier(required[i]) != g.Tier(required[j]) {
+			return g.Tier(required[i]) < g.Tier(required[j])
+		}
+		return required[i] < required[j]
+	})
+	return required
 }
 
 func handleTest(c *gin.Context) {
@@ -1461,9 +1003,33 @@ func handleTest(c *gin.Context) {
 }
 
 func main() {
+	// Check the data files instead of serving them
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
+	// The warm-up runs as the data loads, so settings come first
+	SEARCH_TIMEOUT = searchTimeout()
+	SEARCH_LIMITS = loadSearchLimits()
+	SEARCH_WORKERS = searchWorkers()
+	SEARCH_CACHE_SIZE = searchCacheSize()
+	searchResults = newSearchCache(SEARCH_CACHE_SIZE)
+	WARM_UP, RECIPE_BOOK_PATH = loadWarmUp()
+	fmt.Println("Search timeout:", SEARCH_TIMEOUT)
+	fmt.Printf("Search limits: %d nodes, %d recipes, %d response nodes\n",
+		SEARCH_LIMITS.nodes, SEARCH_LIMITS.recipes, SEARCH_LIMITS.treeNodes)
+	fmt.Println("Search workers:", SEARCH_WORKERS)
+	fmt.Println("Search cache size:", SEARCH_CACHE_SIZE)
+	fmt.Println("Recipe index warm-up:", WARM_UP)
+
 	// Initialize data
 	INITIALIZE()
 
+	// Pick up edits to the data files without a restart
+	if interval := reloadInterval(); interval > 0 {
+		go watchDataFiles(interval)
+	}
+
 	// Set Gin to release mode for production
 	gin.SetMode(gin.ReleaseMode)
 
@@ -1485,11 +1051,18 @@ func main() {
 	})
 
 	// Serve static files
-	r.Static("/images", "./data/images")
+	r.Static("/images", IMAGES_DIR)
 
 	// API routes
 	r.POST("/api", handleSearch)
+	r.POST("/api/stream", handleSearchStream)
+	r.POST("/api/plan", handlePlan)
+	r.POST("/api/count", handleCount)
+	r.GET("/api/elements/:name/uses", handleUses)
+	r.GET("/api/cache", handleCacheStats)
+	r.GET("/api/recipe-book", handleRecipeBook)
 	r.GET("/test", handleTest)
+	r.POST("/admin/reload", handleReload)
 
 	// Start the server
 	port := ":8080"
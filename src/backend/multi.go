@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecipeTree is one complete recipe tree of a multi-recipe search, laid out
+// on its own
+type RecipeTree struct {
+	Hash   string      `json:"hash"` // Same for trees that only differ in ingredient order
+	Nodes  int         `json:"nodes"`
+	Images []ImageInfo `json:"images"`
+	Lines  []LineInfo  `json:"lines"`
+}
+
+// treeOptions limits the recipe trees a search may build
+type treeOptions struct {
+	includeHigher bool         // Also use recipes with ingredients of a higher tier
+	maxDepth      int          // Deepest level below the target, 0 for no limit
+	require       []string     // Elements every tree must contain
+	limits        searchLimits // Caps on the work and the answer, zero for none
+}
+
+// PATH_MEMO_SIZE caps the ancestor sets one search remembers what can be made
+// without. Every set holds a tier per element, so the memo starts over once
+// it is full rather than growing with the search.
+const PATH_MEMO_SIZE = 512
+
+// pathMemo remembers, per set of ancestors, the tiers of the elements that
+// can still be made without them and how far required elements are from the
+// elements that can host them. Backtracking expands the same nodes under the
+// same ancestors over and over, and settling the tiers is by far the most
+// expensive part of an expansion with includeHigher.
+type pathMemo struct {
+	g     *graph.RecipeGraph
+	paths map[string]*pathFacts   // sorted ancestor names -> facts
+	steps map[pathStep]*pathFacts // facts one level below other facts
+	nodes map[*tree]*pathFacts    // facts of the nodes looked up so far
+}
+
+// pathFacts is what can still be made below one set of ancestors
+type pathFacts struct {
+	tiers  map[string]int            // element -> tier without the ancestors
+	levels map[string]map[string]int // required element -> levelsAbove it
+}
+
+// pathStep is the set of ancestors of above together with name
+type pathStep struct {
+	above *pathFacts
+	name  string
+}
+
+func newPathMemo(g *graph.RecipeGraph) *pathMemo {
+	return &pathMemo{
+		g:     g,
+		paths: make(map[string]*pathFacts),
+		steps: make(map[pathStep]*pathFacts),
+		nodes: make(map[*tree]*pathFacts),
+	}
+}
+
+// below returns the facts of the path from the root down to node, node
+// included. Nodes and steps are looked up first, so the ancestor names only
+// need sorting for a step taken for the first time.
+func (m *pathMemo) below(node *tree) *pathFacts {
+	if facts, ok := m.nodes[node]; ok {
+		return facts
+	}
+
+	var step pathStep
+	if node.parent != nil {
+		step = pathStep{above: m.below(node.parent), name: node.now}
+		if facts, ok := m.steps[step]; ok {
+			m.nodes[node] = facts
+			return facts
+		}
+	}
+
+	names := make([]string, 0, node.depth+1)
+	for parent := node; parent != nil; parent = parent.parent {
+		names = append(names, parent.now)
+	}
+	slices.Sort(names)
+	key := strings.Join(names, "\x00")
+
+	facts, ok := m.paths[key]
+	if !ok {
+		if len(m.paths) == PATH_MEMO_SIZE {
+			clear(m.paths)
+			clear(m.steps)
+			clear(m.nodes)
+		}
+
+		ancestors := make(map[string]bool, len(names))
+		for _, name := range names {
+			ancestors[name] = true
+		}
+		facts = &pathFacts{
+			tiers:  m.g.TiersAvoiding(ancestors),
+			levels: make(map[string]map[string]int),
+		}
+		m.paths[key] = facts
+	}
+
+	// Nodes come and go with every recipe tried, so they are only
+	// remembered up to a point
+	if len(m.nodes) >= 64*PATH_MEMO_SIZE {
+		clear(m.nodes)
+	}
+	if node.parent != nil {
+		m.steps[step] = facts
+	}
+	m.nodes[node] = facts
+	return facts
+}
+
+// levelsAbove returns levelsAbove of the required element name below the
+// ancestors of facts
+func (facts *pathFacts) levelsAbove(g *graph.RecipeGraph, name string) map[string]int {
+	levels, ok := facts.levels[name]
+	if !ok {
+		levels = levelsAbove(g, name, true, facts.tiers)
+		facts.levels[name] = levels
+	}
+	return levels
+}
+
+// treeRecipes returns the recipes node may be expanded with, lowest tier
+// first. Without includeHigher only recipes whose ingredients have a lower
+// tier than node are used, which also rules out cycles; with it any recipe
+// goes as long as both ingredients can still be made without node or one of
+// its ancestors, so every recipe offered leads to at least one full tree and
+// the enumeration never wanders into dead ends. With maxDepth above 0 both
+// ingredients must also fit in the levels left below node.
+func treeRecipes(g *graph.RecipeGraph, node *tree, opts treeOptions, paths *pathMemo) []graph.Pair {
+	pairs := make([]graph.Pair, 0)
+
+	// An ingredient with a lower tier than every ancestor keeps its tier
+	// minimal tree clear of them, so only the others need the full check
+	lowest := g.Tier(node.now)
+	for parent := node.parent; parent != nil; parent = parent.parent {
+		lowest = min(lowest, g.Tier(parent.now))
+	}
+	levels := -1 // levels left below an ingredient, -1 for no limit
+	if opts.maxDepth > 0 {
+		levels = opts.maxDepth - node.depth - 1
+	}
+	fits := func(tier int) bool {
+		return levels == -1 || tier <= levels
+	}
+
+	var makeable map[string]int
+	canMake := func(name string) bool {
+		if g.Tier(name) < lowest {
+			return fits(g.Tier(name))
+		}
+		if makeable == nil {
+			makeable = paths.below(node).tiers
+		}
+		tier, ok := makeable[name]
+		return ok && fits(tier)
+	}
+
+	for _, pair := range graph.UniqueRecipes(g.Recipes(node.now)) {
+		if g.Tier(pair.First) == -1 || g.Tier(pair.Second) == -1 {
+			continue
+		}
+
+		if opts.includeHigher {
+			if !canMake(pair.First) || !canMake(pair.Second) {
+				continue
+			}
+		} else if tier := max(g.Tier(pair.First), g.Tier(pair.Second)); tier >= g.Tier(node.now) || !fits(tier) {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return max(g.Tier(pairs[i].First), g.Tier(pairs[i].Second)) < max(g.Tier(pairs[j].First), g.Tier(pairs[j].Second))
+	})
+	return pairs
+}
+
+// levelsAbove returns every element with a recipe tree, as treeRecipes
+// builds them, that can contain name below it, with the fewest levels in
+// between. When makeable is not nil only the elements in it are used.
+func levelsAbove(g *graph.RecipeGraph, name string, includeHigher bool, makeable map[string]int) map[string]int {
+	usable := func(name string) bool {
+		if makeable == nil {
+			return g.Tier(name) != -1
+		}
+		_, ok := makeable[name]
+		return ok
+	}
+
+	levels := make(map[string]int)
+	if !usable(name) {
+		return levels
+	}
+	levels[name] = 0
+	queue := []string{name}
+	for len(queue) > 0 {
+		ingredient := queue[0]
+		queue = queue[1:]
+
+		for _, result := range g.NextElements(ingredient) {
+			if _, ok := levels[result]; ok || g.IsBase(result) || !usable(result) {
+				continue
+			}
+			for _, pair := range g.Recipes(result) {
+				if pair.First != ingredient && pair.Second != ingredient {
+					continue
+				}
+				if !usable(pair.First) || !usable(pair.Second) {
+					continue
+				}
+				if !includeHigher && max(g.Tier(pair.First), g.Tier(pair.Second)) >= g.Tier(result) {
+					continue
+				}
+				levels[result] = levels[ingredient] + 1
+				queue = append(queue, result)
+				break
+			}
+		}
+	}
+	return levels
+}
+
+// hostSplit hands the required elements of a node over to the ingredients of
+// one of its recipes
+type hostSplit struct {
+	pair     graph.Pair
+	first    []string // required elements the first ingredient's subtree must contain
+	second   []string // same for the second ingredient
+	distance int      // total levels the handed over elements still are away
+}
+
+// splitHosts returns every way of handing hosts over to the ingredients of
+// pair: a required element that is an ingredient itself is done, any other
+// must go to an ingredient that can still contain it
+func splitHosts(g *graph.RecipeGraph, pair graph.Pair, hosts []string, above map[string]map[string]int) []hostSplit {
+	splits := []hostSplit{{pair: pair}}
+	for _, name := range hosts {
+		if pair.First == name || pair.Second == name {
+			continue
+		}
+
+		next := make([]hostSplit, 0, 2*len(splits))
+		for _, split := range splits {
+			if levels, ok := above[name][pair.First]; ok && !g.IsBase(pair.First) {
+				next = append(next, hostSplit{
+					pair:     pair,
+					first:    append(slices.Clip(split.first), name),
+					second:   split.second,
+					distance: split.distance + levels,
+				})
+			}
+			if levels, ok := above[name][pair.Second]; ok && !g.IsBase(pair.Second) && pair.Second != pair.First {
+				next = append(next, hostSplit{
+					pair:     pair,
+					first:    split.first,
+					second:   append(slices.Clip(split.second), name),
+					distance: split.distance + levels,
+				})
+			}
+		}
+		splits = next
+	}
+	return splits
+}
+
+// enumerateTrees returns up to count distinct full recipe trees for target.
+// Every node of the partial tree waiting for a recipe sits in a frontier, and
+// the search backtracks over the recipe chosen for each of them. With
+// breadthFirst the frontier is a queue, so recipes are decided level by
+// level and later trees differ from earlier ones first in their deepest
+// levels; otherwise it is a stack and nodes are decided in preorder.
+//
+// Required elements are routed explicitly: the root hosts all of them, and a
+// host node only takes recipes that either use a required element directly or
+// hand it to an ingredient that can still contain it, closest first. Every
+// tree containing them has such a route, so none is missed. Host nodes are
+// decided before any other node, so a route that runs into a dead end is
+// abandoned before the rest of the tree is filled in around it.
+//
+// When ctx is done or one of opts.limits is hit the search stops and returns
+// the trees found so far together with the context's error or a *Truncation.
+func enumerateTrees(ctx context.Context, g *graph.RecipeGraph, target string, count int, breadthFirst bool, opts treeOptions, stats *SearchStats) ([]*tree, searchCounts, error) {
+	requested := count
+	if opts.limits.recipes > 0 {
+		count = min(count, opts.limits.recipes)
+	}
+
+	root := &tree{now: target}
+	trees := make([]*tree, 0, count)
+	seen := make(map[string]bool)
+	var stopped error
+
+	size := 1      // nodes in the partial tree
+	treeNodes := 0 // nodes over all trees found
+	counts := searchCounts{}
+	truncate := func(limit string) {
+		stopped = &Truncation{Limit: limit, Requested: requested}
+	}
+
+	paths := newPathMemo(g)
+
+	// Without includeHigher tiers fall along every path, so ancestors never
+	// block a route and the distances can be shared by the whole search
+	static := make(map[string]map[string]int, len(opts.require))
+	hosts := make(map[*tree][]string) // open node -> required elements its subtree must contain
+	for _, name := range opts.require {
+		if name != target {
+			if !opts.includeHigher {
+				static[name] = levelsAbove(g, name, false, nil)
+			}
+			hosts[root] = append(hosts[root], name)
+		}
+	}
+
+	// above returns how far the required elements of node are from each
+	// element that may still host them below node
+	above := func(node *tree) map[string]map[string]int {
+		if !opts.includeHigher || len(hosts[node]) == 0 {
+			return static
+		}
+		facts := paths.below(node)
+		levels := make(map[string]map[string]int, len(hosts[node]))
+		for _, name := range hosts[node] {
+			levels[name] = facts.levelsAbove(g, name)
+		}
+		return levels
+	}
+
+	var expand func(frontier []*tree) bool
+	expand = func(frontier []*tree) bool {
+		if stopped = ctx.Err(); stopped != nil {
+			return false
+		}
+		counts.maxFrontier = max(counts.maxFrontier, len(frontier))
+		if len(frontier) == 0 {
+			hash := canonicalHash(root)
+			if !seen[hash] {
+				if opts.limits.treeNodes > 0 && treeNodes+size > opts.limits.treeNodes {
+					truncate("response_size")
+					return false
+				}
+				seen[hash] = true
+				stats.keep(root)
+				trees = append(trees, cloneTree(root, nil))
+				treeNodes += size
+			}
+			if len(trees) == count && count < requested {
+				truncate("recipes")
+			}
+			return len(trees) < count
+		}
+
+		if opts.limits.nodes > 0 && counts.expanded == opts.limits.nodes {
+			truncate("nodes")
+			return false
+		}
+		counts.expanded++
+
+		pick := len(frontier) - 1
+		if breadthFirst {
+			pick = 0
+		}
+		for i := 0; i < len(frontier) && len(hosts) > 0; i++ {
+			if len(hosts[frontier[i]]) > 0 {
+				pick = i
+				break
+			}
+		}
+		node := frontier[pick]
+		rest := make([]*tree, 0, len(frontier)+1)
+		rest = append(rest, frontier[:pick]...)
+		rest = append(rest, frontier[pick+1:]...)
+		// Steps of earlier expansions of node were undone by backtracking.
+		// Trees found before share their slice, so it is replaced.
+		expandStep := stats.emitExpand(node.now, parentName(node), node.depth, len(rest))
+		node.steps = []int{expandStep}
+
+		splits := make([]hostSplit, 0)
+		levels := above(node)
+		for _, pair := range treeRecipes(g, node, opts, paths) {
+			splits = append(splits, splitHosts(g, pair, hosts[node], levels)...)
+		}
+		sort.SliceStable(splits, func(i, j int) bool {
+			return splits[i].distance < splits[j].distance
+		})
+
+		for _, split := range splits {
+			left := &tree{now: split.pair.First, depth: node.depth + 1, parent: node}
+			right := &tree{now: split.pair.Second, depth: node.depth + 1, parent: node}
+			node.children = []*tree{left, right}
+			node.childCount = 2
+			hosts[left], hosts[right] = split.first, split.second
+			size += 2
+			node.steps = []int{expandStep, stats.emitRecipe(node.now, parentName(node), node.depth, split.pair)}
+
+			next := rest
+			if breadthFirst {
+				next = appendUnlessBase(g, next, left, right)
+			} else {
+				next = appendUnlessBase(g, next, right, left) // left on top
+			}
+
+			complete := expand(next)
+
+			delete(hosts, left)
+			delete(hosts, right)
+			size -= 2
+			if !complete {
+				return false
+			}
+		}
+
+		node.children = nil
+		node.childCount = 0
+		stats.emitBacktrack(node.now, parentName(node), node.depth)
+		return true
+	}
+
+	frontier := make([]*tree, 0)
+	if !g.IsBase(target) {
+		frontier = append(frontier, root)
+	}
+	expand(frontier)
+
+	if truncation, ok := stopped.(*Truncation); ok {
+		truncation.Returned = len(trees)
+		truncation.Expanded = counts.expanded
+		truncation.TreeNodes = treeNodes
+	}
+	return trees, counts, stopped
+}
+
+// appendUnlessBase appends the nodes that still need a recipe
+func appendUnlessBase(g *graph.RecipeGraph, frontier []*tree, nodes ...*tree) []*tree {
+	for _, node := range nodes {
+		if !g.IsBase(node.now) {
+			frontier = append(frontier, node)
+		}
+	}
+	return frontier
+}
+
+// cloneTree deep copies the names and shape of a tree, sharing the steps
+// that built it
+func cloneTree(node *tree, parent *tree) *tree {
+	clone := &tree{now: node.now, depth: node.depth, parent: parent, childCount: node.childCount, steps: node.steps}
+	for _, child := range node.children {
+		clone.children = append(clone.children, cloneTree(child, clone))
+	}
+	return clone
+}
+
+// canonicalHash identifies a recipe tree regardless of the order in which
+// the ingredients of each recipe are listed
+func canonicalHash(root *tree) string {
+	var canonical func(node *tree) string
+	canonical = func(node *tree) string {
+		if len(node.children) == 0 {
+			return node.now
+		}
+		left, right := canonical(node.children[0]), canonical(node.children[1])
+		if right < left {
+			left, right = right, left
+		}
+		return node.now + "(" + left + "," + right + ")"
+	}
+
+	sum := sha256.Sum256([]byte(canonical(root)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// layoutRecipeTree numbers the nodes of a single recipe tree breadth first,
+// lays it out and converts it for the response
+func layoutRecipeTree(c *gin.Context, root *tree) RecipeTree {
+	numberInOrder(root, false)
+	return layoutNumberedTree(c, root)
+}
+
+// layoutNumberedTree lays out a recipe tree whose nodes already have their
+// ids and converts it for the response
+func layoutNumberedTree(c *gin.Context, root *tree) RecipeTree {
+	existingTree := make([]*tree, 0)
+	getTidyTree(root, &existingTree)
+
+	images := make([]ImageInfo, 0, len(existingTree))
+	lines := make([]LineInfo, 0)
+	for _, node := range existingTree {
+		images = append(images, ImageInfo{
+			Link: getImageURL(c, strings.ReplaceAll(node.now, " ", "_")),
+			Row:  node.posY,
+			Col:  node.posX,
+			Name: node.now,
+			Id:   node.id,
+		})
+
+		for i := 0; i < node.childCount; i += 2 {
+			left := node.children[i]
+			right := node.children[i+1]
+
+			lines = append(lines, LineInfo{
+				From_x:  left.posX,
+				From_y:  left.posY,
+				From_Id: left.id,
+				To_x:    right.posX,
+				To_y:    right.posY,
+				To_Id:   right.id,
+			})
+
+			lines = append(lines, LineInfo{
+				From_x:  (right.posX + left.posX) / 2,
+				From_y:  right.posY,
+				From_Id: right.id,
+				To_x:    node.posX,
+				To_y:    node.posY,
+				To_Id:   left.id,
+			})
+		}
+	}
+
+	return RecipeTree{
+		Hash:   canonicalHash(root),
+		Nodes:  len(existingTree),
+		Images: images,
+		Lines:  lines,
+	}
+}
+
+// multiRecipes lays out up to count distinct recipe trees for target. The
+// trees found before ctx was done are laid out even when it returns an error.
+func multiRecipes(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, count int, breadthFirst bool, opts treeOptions, stats *SearchStats) ([]RecipeTree, error) {
+	count = max(count, 1)
+
+	roots, counts, err := enumerateTrees(ctx, g, target, count, breadthFirst, opts, stats)
+	stats.add(counts)
+
+	defer stats.addLayout(time.Now())
+	recipes := make([]RecipeTree, 0, len(roots))
+	for _, root := range roots {
+		recipes = append(recipes, layoutRecipeTree(c, root))
+	}
+	return recipes, err
+}
+
+func multiDFS(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, count int, opts treeOptions, stats *SearchStats) ([]RecipeTree, error) {
+	return multiRecipes(ctx, c, g, target, count, false, opts, stats)
+}
+
+func multiBFS(ctx context.Context, c *gin.Context, g *graph.RecipeGraph, target string, count int, opts treeOptions, stats *SearchStats) ([]RecipeTree, error) {
+	return multiRecipes(ctx, c, g, target, count, true, opts, stats)
+}
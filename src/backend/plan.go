@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PLAN_SEARCH_BUDGET bounds the search nodes spent proving a plan minimal;
+// past it the best plan found so far is returned as not optimal
+const PLAN_SEARCH_BUDGET = 200000
+
+type PlanResponse struct {
+	*graph.Plan
+	Images []ImageInfo `json:"images"`
+	Lines  []LineInfo  `json:"lines"`
+}
+
+// layoutPlan places every element of a plan exactly once. Elements are
+// layered by their longest chain of combinations from the leaves, with the
+// target at the bottom like the root of the tidy trees, and each layer is
+// ordered by the average position of the ingredients feeding it to keep
+// crossings down.
+func layoutPlan(c *gin.Context, plan *graph.Plan) ([]ImageInfo, []LineInfo) {
+	level := make(map[string]int)
+	order := make([]string, 0) // leaves first, then results in crafting order
+	for _, step := range plan.Steps {
+		for _, ingredient := range []string{step.First, step.Second} {
+			if _, ok := level[ingredient]; !ok {
+				level[ingredient] = 0
+				order = append(order, ingredient)
+			}
+		}
+		level[step.Result] = max(level[step.First], level[step.Second]) + 1
+		order = append(order, step.Result)
+	}
+	if len(plan.Steps) == 0 {
+		level[plan.Target] = 0
+		order = append(order, plan.Target)
+	}
+
+	maxLevel := 0
+	layers := make(map[int][]string)
+	for _, name := range order {
+		layers[level[name]] = append(layers[level[name]], name)
+		maxLevel = max(maxLevel, level[name])
+	}
+
+	ingredients := make(map[string][]string)
+	for _, step := range plan.Steps {
+		ingredients[step.Result] = []string{step.First, step.Second}
+	}
+
+	posX := make(map[string]int)
+	spacing := NODE_WIDTH + SIBLING_SEP
+	minX := 0
+	for l := 0; l <= maxLevel; l++ {
+		layer := layers[l]
+		if l > 0 {
+			barycenter := func(name string) int {
+				return (posX[ingredients[name][0]] + posX[ingredients[name][1]]) / 2
+			}
+			sort.SliceStable(layer, func(i, j int) bool {
+				return barycenter(layer[i]) < barycenter(layer[j])
+			})
+		}
+
+		// Center every layer on the same axis
+		for i, name := range layer {
+			posX[name] = i*spacing - (len(layer)-1)*spacing/2
+			minX = min(minX, posX[name])
+		}
+	}
+
+	id := make(map[string]int, len(order))
+	for i, name := range order {
+		id[name] = i
+	}
+
+	images := make([]ImageInfo, 0, len(order))
+	for _, name := range order {
+		images = append(images, ImageInfo{
+			Link: getImageURL(c, strings.ReplaceAll(name, " ", "_")),
+			Row:  -(maxLevel - level[name]) * LEVEL_SEP,
+			Col:  posX[name] - minX,
+			Name: name,
+			Id:   id[name],
+		})
+	}
+
+	lines := make([]LineInfo, 0, 2*len(plan.Steps))
+	for _, step := range plan.Steps {
+		from := []string{step.First, step.Second}
+		if step.First == step.Second {
+			from = from[:1]
+		}
+		for _, ingredient := range from {
+			lines = append(lines, LineInfo{
+				From_x:  posX[ingredient] - minX,
+				From_y:  -(maxLevel - level[ingredient]) * LEVEL_SEP,
+				From_Id: id[ingredient],
+				To_x:    posX[step.Result] - minX,
+				To_y:    -(maxLevel - level[step.Result]) * LEVEL_SEP,
+				To_Id:   id[step.Result],
+			})
+		}
+	}
+
+	return images, lines
+}
+
+// handlePlan answers POST /api/plan with a crafting plan in which every
+// intermediate element is made only once
+func handlePlan(c *gin.Context) {
+	req, ok := bindSearchRequest(c)
+	if !ok {
+		return
+	}
+
+	if failure := checkSearch(req); failure != nil {
+		failure.answer(c)
+		return
+	}
+
+	plan, err := req.graph.Plan(req.target, PLAN_SEARCH_BUDGET, req.MaxDepth)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	images, lines := layoutPlan(c, plan)
+
+	c.JSON(http.StatusOK, PlanResponse{
+		Plan:   plan,
+		Images: images,
+		Lines:  lines,
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dataset is one loaded snapshot of the recipe data. A snapshot is never
+// modified after it is published, so a request that picked it up keeps
+// working against it even if a reload swaps in a newer one meanwhile.
+type dataset struct {
+	graph    *graph.RecipeGraph
+	resolver *graph.Resolver
+	version  int
+	index    *shortestIndex // shortest tree of every element, nil without warm-up
+}
+
+// currentDataset is the snapshot served by the API
+var currentDataset atomic.Pointer[dataset]
+
+// reloadMu serializes reloads so two triggers never build side by side
+var reloadMu sync.Mutex
+
+// loadDataset builds and validates a fresh graph from the data files and
+// swaps it in. On any error the previous snapshot keeps serving.
+func loadDataset() (*dataset, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	base, err := graph.LoadBaseElements(BASE_PATH)
+	if err != nil {
+		return nil, err
+	}
+
+	// Image files are only worth warnings, so skip checking them here
+	report := graph.Validate(RECIPES_PATH, IMAGES_PATH, "", base)
+	if report.Errors() > 0 {
+		for _, issue := range report.Issues {
+			if issue.Severity == graph.SeverityError {
+				return nil, fmt.Errorf("%d validation errors, first: %s:%d: %s",
+					report.Errors(), issue.File, issue.Line, issue.Message)
+			}
+		}
+	}
+
+	g, err := graph.Load(RECIPES_PATH, IMAGES_PATH, base)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := graph.LoadAliases(ALIASES_PATH)
+	if err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if old := currentDataset.Load(); old != nil {
+		version = old.version + 1
+	}
+
+	ds := &dataset{
+		graph:    g,
+		resolver: graph.NewResolver(g, aliases),
+		version:  version,
+	}
+	if WARM_UP {
+		ds.index = warmUpIndex(g, version)
+	}
+	currentDataset.Store(ds)
+
+	// Cached answers are keyed by version, so none would be served again
+	searchResults.purge()
+
+	return ds, nil
+}
+
+// reloadInterval is how often the data files are polled for changes. It can
+// be overridden with RELOAD_INTERVAL (e.g. "30s"); "0" disables the watcher.
+func reloadInterval() time.Duration {
+	interval := 5 * time.Second
+
+	if env := os.Getenv("RELOAD_INTERVAL"); env != "" {
+		parsed, err := time.ParseDuration(env)
+		if err != nil {
+			fmt.Printf("Invalid RELOAD_INTERVAL %q, using %s\n", env, interval)
+			return interval
+		}
+		interval = parsed
+	}
+
+	return interval
+}
+
+// watchDataFiles polls the modification times of the data files and reloads
+// the dataset whenever one of them changes
+func watchDataFiles(interval time.Duration) {
+	// A file that does not exist has the zero time, so creating or
+	// deleting one of the optional files is noticed as well
+	modTimes := func() [4]time.Time {
+		var times [4]time.Time
+		for i, path := range []string{RECIPES_PATH, IMAGES_PATH, ALIASES_PATH, BASE_PATH} {
+			if info, err := os.Stat(path); err == nil {
+				times[i] = info.ModTime()
+			}
+		}
+		return times
+	}
+
+	last := modTimes()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		times := modTimes()
+		if times == last {
+			continue
+		}
+
+		// Remember the new times even if the reload fails, so a bad file is
+		// reported once rather than on every tick until it is fixed
+		last = times
+
+		fmt.Println("Data files changed, reloading...")
+		ds, err := loadDataset()
+		if err != nil {
+			fmt.Println("Reload rejected, keeping current data:", err)
+			continue
+		}
+		fmt.Printf("Reloaded dataset version %d\n", ds.version)
+	}
+}
+
+// handleReload reloads the dataset on demand. The request must carry
+// ADMIN_TOKEN as a bearer token, and without one configured the endpoint is
+// refused, since a reload rebuilds everything and drops the search cache.
+func handleReload(c *gin.Context) {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "reloading is disabled, set ADMIN_TOKEN to enable it"})
+		return
+	}
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	ds, err := loadDataset()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   err.Error(),
+			"version": currentDataset.Load().version,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"version":  ds.version,
+		"elements": len(ds.graph.Elements()),
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"scraper/graph"
+)
+
+// SearchStats is the work one search did, so methods can be compared on real
+// numbers
+type SearchStats struct {
+	Method        string       `json:"method"`            // Method that actually ran
+	Options       StatsOptions `json:"options"`           // Options it actually ran with
+	NodesExpanded int          `json:"nodes_expanded"`    // Nodes expanded, repeated work included
+	TreeNodes     int          `json:"tree_nodes"`        // Nodes in the tree returned, the first one for multi-recipe searches
+	MaxFrontier   int          `json:"max_frontier"`      // Most nodes waiting to be expanded at once
+	SearchMs      float64      `json:"search_ms"`         // Wall-clock time spent searching
+	LayoutMs      float64      `json:"layout_ms"`         // Wall-clock time spent laying out trees
+	Indexed       bool         `json:"indexed,omitempty"` // The tree was read from the recipe index instead of searched
+
+	layout time.Duration
+	listen func(SearchEvent) // called for every step when not nil, possibly concurrently
+	trace  *searchTrace      // records every step when not nil
+}
+
+// StatsOptions are the request options that took part in a search. Options a
+// method ignores are left out.
+type StatsOptions struct {
+	Option        string   `json:"option,omitempty"`         // Shortest or Multiple, for DFS, BFS and Bidirectional
+	NumOfRecipes  int      `json:"num_of_recipes,omitempty"` // Trees searched for, after the limits
+	IncludeHigher bool     `json:"include_higher"`
+	MaxDepth      int      `json:"max_depth,omitempty"`
+	Exclude       []string `json:"exclude,omitempty"`
+	Require       []string `json:"require,omitempty"`
+	Objective     string   `json:"objective,omitempty"` // What the Cost method minimized
+	Deterministic bool     `json:"deterministic"`
+	Workers       int      `json:"workers,omitempty"` // Worker pool size of the shortest DFS and BFS
+}
+
+// searchCounts is the work done by one run of a search algorithm
+type searchCounts struct {
+	expanded    int // nodes expanded, repeated work included
+	maxFrontier int // most nodes waiting to be expanded at once
+}
+
+// add records the work of one run of a search algorithm
+func (s *SearchStats) add(counts searchCounts) {
+	s.NodesExpanded += counts.expanded
+	s.MaxFrontier = max(s.MaxFrontier, counts.maxFrontier)
+}
+
+// addLayout records the time spent laying out trees since start
+func (s *SearchStats) addLayout(start time.Time) {
+	s.layout += time.Since(start)
+}
+
+// finish splits the total time of a search into search and layout time
+func (s *SearchStats) finish(total time.Duration) {
+	s.SearchMs = milliseconds(total - s.layout)
+	s.LayoutMs = milliseconds(s.layout)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// SearchEvent is one step of a running search
+type SearchEvent struct {
+	Type     string `json:"type"`             // expand, recipe, backtrack, or meet where the bidirectional sides join
+	Node     string `json:"node"`             // Element the step is about
+	Parent   string `json:"parent,omitempty"` // Element Node is an ingredient of, if known yet
+	Depth    int    `json:"depth"`            // Levels below the target, or above the base elements for the source side
+	First    string `json:"first,omitempty"`  // Ingredients chosen in a recipe step
+	Second   string `json:"second,omitempty"` // Same
+	Frontier int    `json:"frontier"`         // Nodes waiting to be expanded, for expand steps
+	Side     string `json:"side,omitempty"`   // source or target, for the bidirectional search
+}
+
+// emit reports a step to the trace and the listener, if there are any, and
+// returns the step's position in the trace, -1 when it is not recorded
+func (s *SearchStats) emit(event SearchEvent) int {
+	if s == nil {
+		return -1
+	}
+	step := -1
+	if s.trace != nil {
+		step = s.trace.record(event)
+	}
+	if s.listen != nil {
+		s.listen(event)
+	}
+	return step
+}
+
+// emitExpand reports that name, an ingredient of parent depth levels down,
+// is being expanded while frontier other nodes wait
+func (s *SearchStats) emitExpand(name string, parent string, depth int, frontier int) int {
+	return s.emit(SearchEvent{Type: "expand", Node: name, Parent: parent, Depth: depth, Frontier: frontier})
+}
+
+// emitRecipe reports that name, an ingredient of parent depth levels down,
+// is made with pair
+func (s *SearchStats) emitRecipe(name string, parent string, depth int, pair graph.Pair) int {
+	return s.emit(SearchEvent{Type: "recipe", Node: name, Parent: parent, Depth: depth, First: pair.First, Second: pair.Second})
+}
+
+// emitBacktrack reports that the recipes tried for name, an ingredient of
+// parent depth levels down, are undone
+func (s *SearchStats) emitBacktrack(name string, parent string, depth int) int {
+	return s.emit(SearchEvent{Type: "backtrack", Node: name, Parent: parent, Depth: depth})
+}
+
+// parentName returns the element node is an ingredient of, or nothing for
+// the root
+func parentName(node *tree) string {
+	if node.parent == nil {
+		return ""
+	}
+	return node.parent.now
+}
+
+// newSearchStats returns the stats of the search handleSearch runs for req,
+// with the method and the options that take part in it filled in
+func newSearchStats(req *searchRequest) *SearchStats {
+	stats := &SearchStats{Method: req.Method}
+	used := StatsOptions{
+		MaxDepth:      req.MaxDepth,
+		Exclude:       req.exclude,
+		Deterministic: req.deterministic,
+	}
+
+	switch req.Method {
+	case "DFS", "BFS":
+		used.Option = "Multiple"
+		if req.Option == "Shortest" {
+			used.Option = "Shortest"
+		}
+		if used.Option == "Shortest" && len(req.require) == 0 {
+			used.Workers = SEARCH_WORKERS
+			break
+		}
+
+		// Everything else enumerates trees
+		used.NumOfRecipes = 1
+		if used.Option == "Multiple" {
+			used.NumOfRecipes = max(req.NumOfRecipes, 1)
+		}
+		if SEARCH_LIMITS.recipes > 0 {
+			used.NumOfRecipes = min(used.NumOfRecipes, SEARCH_LIMITS.recipes)
+		}
+		used.IncludeHigher = req.IncludeHigher
+		used.Require = req.require
+	case "IDDFS", "AStar":
+		// Only the depth limit and the exclusions apply
+	case "Cost":
+		used.Objective = strings.ToLower(strings.TrimSpace(req.Objective))
+		if used.Objective == "" {
+			used.Objective = "combinations"
+		}
+	default:
+		stats.Method = "Bidirectional"
+		used.Option = "Shortest"
+		used.NumOfRecipes = 1
+		if req.Option == "Multiple" {
+			used.Option = "Multiple"
+			used.NumOfRecipes = max(req.NumOfRecipes, 1)
+		}
+		if SEARCH_LIMITS.recipes > 0 {
+			used.NumOfRecipes = min(used.NumOfRecipes, SEARCH_LIMITS.recipes)
+		}
+		used.Require = req.require
+	}
+
+	stats.Options = used
+	if req.Trace {
+		stats.trace = &searchTrace{trace: SearchTrace{Events: make([]TraceEvent, 0)}}
+	}
+	return stats
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+// STREAM_EVENT_LIMIT caps the steps streamed for one search. Large searches
+// expand millions of nodes, far more than a client can animate, so the
+// steps after it are counted but not sent.
+const STREAM_EVENT_LIMIT = 50000
+
+// STREAM_BUFFER is how many steps may wait for the client before the search
+// waits for it
+const STREAM_BUFFER = 1024
+
+// streamOutcome is how a streamed search ended
+type streamOutcome struct {
+	response *Response
+	failure  *searchFailure
+}
+
+/*	Streaming search
+*
+*	Runs the same search as /api and sends every step as a server-sent event
+*	while it runs: "expand" when a node is expanded, with the frontier size,
+*	"recipe" when a recipe is chosen for a node and "backtrack" when one is
+*	undone. The last event is either "layout", with the Response /api would
+*	answer with, or "error" with the status and body /api would answer with.
+*
+*	The search runs on its own goroutine and hands steps over a buffered
+*	channel, so a slow client slows the search down instead of piling steps
+*	up in memory.
+ */
+func handleSearchStream(c *gin.Context) {
+	req, ok := bindSearchRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := searchContext(c)
+	defer cancel()
+
+	events := make(chan SearchEvent, STREAM_BUFFER)
+	done := make(chan streamOutcome, 1)
+
+	stats := newSearchStats(req)
+	stats.listen = func(event SearchEvent) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(events)
+		if failure := checkSearch(req); failure != nil {
+			done <- streamOutcome{failure: failure}
+			return
+		}
+		response, failure := runSearch(ctx, c, req, stats)
+		done <- streamOutcome{response: response, failure: failure}
+	}()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // keep proxies from holding events back
+
+	sent, dropped := 0, 0
+	c.Stream(func(w io.Writer) bool {
+		event, ok := <-events
+		if !ok {
+			return false
+		}
+		if sent >= STREAM_EVENT_LIMIT {
+			dropped++
+			return true
+		}
+		sent++
+		c.SSEvent(event.Type, event)
+		return true
+	})
+
+	// The search is over once it stops sending steps
+	outcome := <-done
+	if c.Request.Context().Err() != nil {
+		return // the client is gone
+	}
+
+	if dropped > 0 {
+		fmt.Printf("Streamed %d steps of the search for %s, left out %d\n", sent, req.target, dropped)
+		c.SSEvent("dropped", gin.H{"steps": dropped})
+	}
+	if outcome.failure != nil {
+		c.SSEvent("error", gin.H{"status": outcome.failure.status, "body": outcome.failure.body})
+		return
+	}
+	c.SSEvent("layout", outcome.response)
+}
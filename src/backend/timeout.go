@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// STATUS_CLIENT_CLOSED_REQUEST is the nonstandard status recorded for a search
+// whose client went away before it finished
+const STATUS_CLIENT_CLOSED_REQUEST = 499
+
+// SEARCH_TIMEOUT bounds how long a single search may run, 0 for no limit
+var SEARCH_TIMEOUT = 30 * time.Second
+
+// searchTimeout is the server side deadline of every search. It can be
+// overridden with SEARCH_TIMEOUT (e.g. "10s"); "0" disables the deadline.
+func searchTimeout() time.Duration {
+	timeout := SEARCH_TIMEOUT
+
+	if env := os.Getenv("SEARCH_TIMEOUT"); env != "" {
+		parsed, err := time.ParseDuration(env)
+		if err != nil || parsed < 0 {
+			fmt.Printf("Invalid SEARCH_TIMEOUT %q, using %s\n", env, timeout)
+			return timeout
+		}
+		timeout = parsed
+	}
+
+	return timeout
+}
+
+// searchContext returns the context a search for c runs under. It is done
+// as soon as the client disconnects or SEARCH_TIMEOUT has passed.
+func searchContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return withSearchTimeout(c.Request.Context())
+}
+
+// withSearchTimeout returns a context that is done when parent is or once
+// SEARCH_TIMEOUT has passed
+func withSearchTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if SEARCH_TIMEOUT == 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, SEARCH_TIMEOUT)
+}
+
+// searchFailed returns the answer to a request whose search for target
+// failed with err
+func searchFailed(target string, err error) *searchFailure {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &searchFailure{http.StatusGatewayTimeout, gin.H{
+			"error": fmt.Sprintf("search for %s timed out after %s", target, SEARCH_TIMEOUT),
+		}}
+	case errors.Is(err, context.Canceled):
+		// Nobody is left to read an answer
+		fmt.Println("Search for", target, "canceled:", err)
+		return &searchFailure{STATUS_CLIENT_CLOSED_REQUEST, nil}
+	default:
+		return &searchFailure{http.StatusUnprocessableEntity, gin.H{"error": err.Error()}}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import "sync"
+
+// MAX_TRACE_EVENTS caps the steps recorded for one search. The steps after it
+// are counted but left out, so a trace of a large search stays answerable.
+const MAX_TRACE_EVENTS = 100000
+
+// TraceEvent is one recorded step of a search
+type TraceEvent struct {
+	SearchEvent
+	Kept bool `json:"kept"` // The step is part of a returned tree, false when it was pruned
+}
+
+// SearchTrace is every step of a search in the order it was taken
+type SearchTrace struct {
+	Events  []TraceEvent `json:"events"`
+	Dropped int          `json:"dropped,omitempty"` // Steps left out past MAX_TRACE_EVENTS
+}
+
+// searchTrace records the steps of a search, possibly from several workers
+type searchTrace struct {
+	mu    sync.Mutex
+	trace SearchTrace
+}
+
+// record adds event to the trace and returns its position, -1 when it is
+// left out
+func (t *searchTrace) record(event SearchEvent) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.trace.Events) == MAX_TRACE_EVENTS {
+		t.trace.Dropped++
+		return -1
+	}
+	t.trace.Events = append(t.trace.Events, TraceEvent{SearchEvent: event})
+	return len(t.trace.Events) - 1
+}
+
+// keep marks the recorded steps that built roots, the trees a search
+// returns. Every node carries the steps that expanded it and chose its
+// recipe on the way to the tree it ended up in, so a step that was undone
+// later is never kept, even when another step made the same element the
+// same way. Backtracking always undoes a step, so it is never kept either.
+func (s *SearchStats) keep(roots ...*tree) {
+	if s == nil || s.trace == nil {
+		return
+	}
+
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+
+	// The bidirectional search shares nodes between branches, so each is
+	// walked once
+	walked := make(map[*tree]bool)
+	var walk func(node *tree)
+	walk = func(node *tree) {
+		if walked[node] {
+			return
+		}
+		walked[node] = true
+
+		for _, step := range node.steps {
+			if step >= 0 {
+				s.trace.trace.Events[step].Kept = true
+			}
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+}
+
+// traced returns the recorded steps, or nil when the search was not traced
+func (s *SearchStats) traced() *SearchTrace {
+	if s.trace == nil {
+		return nil
+	}
+
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	return &s.trace.trace
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"scraper/graph"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MAX_USES_DEPTH caps the forward closure, which grows very quickly: every
+// base element already leads to hundreds of elements within three steps
+const MAX_USES_DEPTH = 3
+
+// Use is one recipe an element is an ingredient of
+type Use struct {
+	Result  string `json:"result"`
+	Partner string `json:"partner"`
+	Tier    int    `json:"tier"`
+}
+
+type UsesResponse struct {
+	Element string      `json:"element"`
+	Tier    int         `json:"tier"`
+	Uses    []Use       `json:"uses"`
+	Images  []ImageInfo `json:"images,omitempty"`
+	Lines   []LineInfo  `json:"lines,omitempty"`
+}
+
+// findUses lists every recipe that uses name, ordered by the result's tier
+func findUses(g *graph.RecipeGraph, name string) []Use {
+	uses := make([]Use, 0)
+	seen := make(map[string]bool)
+
+	for _, result := range g.NextElements(name) {
+		// nextElements has an entry per ingredient, so A + A shows up twice
+		if seen[result] {
+			continue
+		}
+		seen[result] = true
+
+		for _, pair := range g.Recipes(result) {
+			if pair.First == name {
+				uses = append(uses, Use{result, pair.Second, g.Tier(result)})
+			} else if pair.Second == name {
+				uses = append(uses, Use{result, pair.First, g.Tier(result)})
+			}
+		}
+	}
+
+	sort.Slice(uses, func(i, j int) bool {
+		if uses[i].Tier != uses[j].Tier {
+			return uses[i].Tier < uses[j].Tier
+		}
+		if uses[i].Result != uses[j].Result {
+			return uses[i].Result < uses[j].Result
+		}
+		return uses[i].Partner < uses[j].Partner
+	})
+
+	return uses
+}
+
+// forwardTree builds the forward closure of name up to depth steps as a tree.
+// Elements are expanded breadth first and each one appears once, under the
+// first element found to make it, so every node sits at its shortest
+// forward distance from the root.
+func forwardTree(g *graph.RecipeGraph, name string, depth int) *tree {
+	root := &tree{now: name}
+	visited := map[string]bool{name: true}
+
+	countId := 0
+	queue := []*tree{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		node.id = countId
+		countId++
+
+		if node.depth == depth {
+			continue
+		}
+
+		for _, use := range findUses(g, node.now) {
+			if visited[use.Result] {
+				continue
+			}
+			visited[use.Result] = true
+
+			child := &tree{now: use.Result, depth: node.depth + 1, parent: node}
+			node.children = append(node.children, child)
+			node.childCount++
+			queue = append(queue, child)
+		}
+	}
+
+	return root
+}
+
+// handleUses answers GET /api/elements/:name/uses
+func handleUses(c *gin.Context) {
+	ds := currentDataset.Load()
+	g := ds.graph
+
+	name, suggestions, ok := ds.resolver.Resolve(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":       fmt.Sprintf("element %q not found", c.Param("name")),
+			"suggestions": suggestions,
+		})
+		return
+	}
+
+	depth := 0
+	if param := c.Query("depth"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < 0 || parsed > MAX_USES_DEPTH {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("depth must be an integer between 0 and %d", MAX_USES_DEPTH),
+			})
+			return
+		}
+		depth = parsed
+	}
+
+	response := UsesResponse{
+		Element: name,
+		Tier:    g.Tier(name),
+		Uses:    findUses(g, name),
+	}
+
+	if depth > 0 {
+		root := forwardTree(g, name, depth)
+
+		existingTree := make([]*tree, 0)
+		getTidyTree(root, &existingTree)
+
+		response.Images = make([]ImageInfo, 0, len(existingTree))
+		response.Lines = make([]LineInfo, 0, len(existingTree))
+		for _, node := range existingTree {
+			response.Images = append(response.Images, ImageInfo{
+				Link: getImageURL(c, strings.ReplaceAll(node.now, " ", "_")),
+				Row:  node.posY,
+				Col:  node.posX,
+				Name: node.now,
+				Id:   node.id,
+			})
+
+			for _, child := range node.children {
+				response.Lines = append(response.Lines, LineInfo{
+					From_x:  node.posX,
+					From_y:  node.posY,
+					From_Id: node.id,
+					To_x:    child.posX,
+					To_y:    child.posY,
+					To_Id:   child.id,
+				})
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
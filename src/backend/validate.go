@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"scraper/graph"
+)
+
+// runValidate implements the "validate" command: it checks the data files
+// and prints a report, returning the process exit code. The code is 1 when
+// errors were found, or warnings too when -strict is given.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	recipesPath := flags.String("recipes", RECIPES_PATH, "path to recipes.csv")
+	imagesPath := flags.String("images", IMAGES_PATH, "path to images.csv")
+	basePath := flags.String("base", BASE_PATH, "path to the base elements file")
+	imagesDir := flags.String("images-dir", IMAGES_DIR, "directory holding the <Element>_2.svg files, empty to skip")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	strict := flags.Bool("strict", false, "treat warnings as errors")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	base, err := graph.LoadBaseElements(*basePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	report := graph.Validate(*recipesPath, *imagesPath, *imagesDir, base)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printReport(report)
+	}
+
+	if report.Errors() > 0 || (*strict && report.Warnings() > 0) {
+		return 1
+	}
+	return 0
+}
+
+// printReport prints the issues grouped by severity and kind
+func printReport(report *graph.Report) {
+	groups := make(map[string][]graph.Issue)
+	keys := make([]string, 0)
+	for _, issue := range report.Issues {
+		key := string(issue.Severity) + " " + issue.Kind
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], issue)
+	}
+	sort.Strings(keys) // errors sort before warnings
+
+	for _, key := range keys {
+		fmt.Printf("%s (%d)\n", key, len(groups[key]))
+		for _, issue := range groups[key] {
+			location := issue.File
+			if issue.Line > 0 {
+				location = fmt.Sprintf("%s:%d", issue.File, issue.Line)
+			}
+			fmt.Printf("  %s: %s\n", location, issue.Message)
+		}
+	}
+
+	fmt.Printf("%d elements, %d recipes, %d errors, %d warnings\n",
+		report.Elements, report.Recipes, report.Errors(), report.Warnings())
+}
@@ -178,22 +178,18 @@ function BurgerMenu({ isLoading, fetchHandler, parameter, onParameterChange, loa
           ))}
         </select>
 
-        {parameter.method !== "Bidirectional" && (
-          <>
-            <label className="text-gray-700 dark:text-gray-300">{t.option}</label>
-            <select
-              name="option"
-              className="menu-select bg-white dark:bg-gray-700 text-black dark:text-white border-gray-300 dark:border-gray-600"
-              value={parameter.option}
-              onChange={onParameterChange}>
-              {options.map((opt, idx) => (
-                <option key={idx} value={opt.value}>
-                  {opt.label}
-                </option>
-              ))}
-            </select>
-          </>
-        )}
+        <label className="text-gray-700 dark:text-gray-300">{t.option}</label>
+        <select
+          name="option"
+          className="menu-select bg-white dark:bg-gray-700 text-black dark:text-white border-gray-300 dark:border-gray-600"
+          value={parameter.option}
+          onChange={onParameterChange}>
+          {options.map((opt, idx) => (
+            <option key={idx} value={opt.value}>
+              {opt.label}
+            </option>
+          ))}
+        </select>
 
         <div className="multiple-extra-option">
           <label className="text-gray-700 dark:text-gray-300">
@@ -210,7 +206,7 @@ function BurgerMenu({ isLoading, fetchHandler, parameter, onParameterChange, loa
           </label>
         </div>
 
-        {isLastOptionSelected && parameter.method !== "Bidirectional" && (
+        {isLastOptionSelected && (
           <>
             <div className="multiple-extra-option">
               <label className="text-gray-700 dark:text-gray-300">
@@ -226,25 +222,27 @@ function BurgerMenu({ isLoading, fetchHandler, parameter, onParameterChange, loa
                 {errors.numOfRecipes && <span className="text-red-500 text-sm mt-1">{errors.numOfRecipes}</span>}
               </label>
             </div>
-            <div className="flex items-center justify-between mt-2 pr-4">
-              <label className="text-gray-700 dark:text-gray-300 w-[60%]">{t.includeHigher}</label>
-
-              <label className="switch">
-                <input
-                  type="checkbox"
-                  checked={parameter.includeHigher}
-                  onChange={() =>
-                    onParameterChange({
-                      target: {
-                        name: "includeHigher",
-                        value: !parameter.includeHigher,
-                      },
-                    })
-                  }
-                />
-                <span className="slider"></span>
-              </label>
-            </div>
+            {parameter.method !== "Bidirectional" && (
+              <div className="flex items-center justify-between mt-2 pr-4">
+                <label className="text-gray-700 dark:text-gray-300 w-[60%]">{t.includeHigher}</label>
+
+                <label className="switch">
+                  <input
+                    type="checkbox"
+                    checked={parameter.includeHigher}
+                    onChange={() =>
+                      onParameterChange({
+                        target: {
+                          name: "includeHigher",
+                          value: !parameter.includeHigher,
+                        },
+                      })
+                    }
+                  />
+                  <span className="slider"></span>
+                </label>
+              </div>
+            )}
           </>
         )}
       </div>
@@ -168,9 +168,13 @@ export default function KonvaCanvas({ onTerminalMessage }) {
       const executionTime = endTime - startTime;
 
       if (onTerminalMessage) {
+        // Older servers send no stats, fall back to what the client can see
+        const stats = data.stats;
+        const nodesVisited = stats ? stats.nodes_expanded : images_data.length;
+        const serverTime = stats ? (stats.search_ms + stats.layout_ms).toFixed(1) : executionTime;
         onTerminalMessage(`✓ ${t.serverResponded}`, "success");
-        onTerminalMessage(`${t.nodesVisited}: ${images_data.length}`, "info");
-        onTerminalMessage(`${t.serverExecutionTime}: ${executionTime}ms`, "info");
+        onTerminalMessage(`${t.nodesVisited}: ${nodesVisited}`, "info");
+        onTerminalMessage(`${t.serverExecutionTime}: ${serverTime}ms`, "info");
       }
 
       let firstImage = images_data[0];